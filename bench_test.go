@@ -10,12 +10,10 @@ import (
 )
 
 func BenchmarkCollect(b *testing.B) {
-	zfsHandle, err := ioctl.NewZFSHandle()
+	c, err := newZFSCollector()
 	if err != nil {
 		b.Fatal(err)
 	}
-
-	c := &zfsCollector{zfsHandle: zfsHandle}
 	c.describe(nil)
 
 	for b.Loop() {
@@ -24,7 +22,7 @@ func BenchmarkCollect(b *testing.B) {
 }
 
 func BenchmarkDecode(b *testing.B) {
-	zfsHandle, err := ioctl.NewZFSHandle()
+	zfsHandle, err := newHandle()
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector exports the metrics of one subsystem of a pool, like its vdevs or datasets.
+type Collector interface {
+	// Describe creates the descriptors of the collector and sends them to ch, unless ch is nil.
+	Describe(ch *chan<- *prometheus.Desc)
+	// Update exports the metrics of pool. Metrics exported before an error are kept.
+	Update(ch *chan<- prometheus.Metric, pool *poolScrape) error
+}
+
+// SystemCollector exports metrics that do not belong to a single pool, like the pools of the zpool cache. It
+// is updated once per scrape, after the collectors of all pools.
+type SystemCollector interface {
+	// Describe creates the descriptors of the collector and sends them to ch, unless ch is nil.
+	Describe(ch *chan<- *prometheus.Desc)
+	// Update exports the metrics, pools holds the configs of the imported pools. Metrics exported before an
+	// error are kept.
+	Update(ch *chan<- prometheus.Metric, pools []ioctl.PoolConfig) error
+}
+
+// collectorConfig holds the settings of the exporter the collectors are created with.
+type collectorConfig struct {
+	// nativeHistograms exports histograms as native histograms instead of classic histograms.
+	nativeHistograms bool
+	// readKStat reads the kstat name of a pool.
+	readKStat func(pool string, name string) ([]byte, error)
+	// zpoolCachePath is the zpool cache file. If it is empty, no cache file is read.
+	zpoolCachePath string
+}
+
+type collectorFactory func(config collectorConfig) Collector
+
+type systemCollectorFactory func(config collectorConfig) SystemCollector
+
+type registeredCollector struct {
+	name string
+	// Only one of factory and systemFactory is set.
+	factory       collectorFactory
+	systemFactory systemCollectorFactory
+	enable        *bool
+	disable       *bool
+}
+
+// registeredCollectors holds the collectors in the order they are updated.
+var registeredCollectors []registeredCollector
+
+// The pool collector runs first, so that no metrics are exported for pools that vanished since they were
+// listed.
+func init() {
+	registerCollector("pool", true, newPoolCollector)
+	registerCollector("vdev", true, newVdevCollector)
+	registerCollector("dataset", true, newDatasetCollector)
+	registerCollector("dataset-kstat", true, newDatasetKStatCollector)
+	registerSystemCollector("zpool-cache", true, newZpoolCacheCollector)
+}
+
+// registerCollector registers a collector run for every pool and adds its -collector.<name> and
+// -no-collector.<name> flags. It has to be called before the flags are parsed.
+func registerCollector(name string, enabledByDefault bool, factory collectorFactory) {
+	registeredCollectors = append(registeredCollectors, newRegisteredCollector(name, enabledByDefault, factory, nil))
+}
+
+// registerSystemCollector is the counterpart of registerCollector for collectors run once per scrape.
+func registerSystemCollector(name string, enabledByDefault bool, factory systemCollectorFactory) {
+	registeredCollectors = append(registeredCollectors, newRegisteredCollector(name, enabledByDefault, nil, factory))
+}
+
+func newRegisteredCollector(name string, enabledByDefault bool, factory collectorFactory, systemFactory systemCollectorFactory) registeredCollector {
+	return registeredCollector{
+		name:          name,
+		factory:       factory,
+		systemFactory: systemFactory,
+		enable:        flag.Bool("collector."+name, enabledByDefault, fmt.Sprintf("Enable the %s collector", name)),
+		disable:       flag.Bool("no-collector."+name, false, fmt.Sprintf("Disable the %s collector", name)),
+	}
+}
+
+// enabledCollectorNames returns the names of the collectors enabled by the flags.
+func enabledCollectorNames() []string {
+	var names []string
+	for _, r := range registeredCollectors {
+		if *r.enable && !*r.disable {
+			names = append(names, r.name)
+		}
+	}
+	return names
+}
+
+type namedCollector struct {
+	name string
+	Collector
+}
+
+type namedSystemCollector struct {
+	name string
+	SystemCollector
+}
+
+// newCollectors creates the registered collectors included in names, in the order they were registered.
+func newCollectors(names []string, config collectorConfig) ([]namedCollector, []namedSystemCollector) {
+	var collectors []namedCollector
+	var systemCollectors []namedSystemCollector
+	for _, r := range registeredCollectors {
+		if !slices.Contains(names, r.name) {
+			continue
+		}
+		if r.systemFactory != nil {
+			systemCollectors = append(systemCollectors, namedSystemCollector{r.name, r.systemFactory(config)})
+		} else {
+			collectors = append(collectors, namedCollector{r.name, r.factory(config)})
+		}
+	}
+	return collectors, systemCollectors
+}
+
+// poolScrape holds what the collectors of a pool share during a scrape. The stats and datasets of the pool
+// are fetched by the first collector asking for them, so that every ioctl is issued at most once.
+type poolScrape struct {
+	ctx  context.Context
+	zfs  *ioctl.Client
+	name string
+	// zfsVersion is the version of the kernel module, used to pick the layout of vdev_stats. If it is empty,
+	// the layout is picked by the length of the array.
+	zfsVersion string
+
+	statsFetched bool
+	stats        *ioctl.PoolStats
+	statsErr     error
+
+	datasetsFetched bool
+	datasets        []*ioctl.Dataset
+	datasetsErr     error
+}
+
+func newPoolScrape(ctx context.Context, zfs *ioctl.Client, name string) *poolScrape {
+	// The version is read for every scrape, as the kernel module may have been reloaded in between.
+	return &poolScrape{ctx: ctx, zfs: zfs, name: name, zfsVersion: zfs.ModuleVersion()}
+}
+
+// Stats returns the stats of the pool, including its vdev tree.
+func (p *poolScrape) Stats() (*ioctl.PoolStats, error) {
+	if !p.statsFetched {
+		p.stats, p.statsErr = p.zfs.PoolStats(p.ctx, p.name)
+		p.statsFetched = true
+	}
+	return p.stats, p.statsErr
+}
+
+// Datasets returns all datasets of the pool, parents before their children. If listing the datasets
+// fails, the datasets listed until then are returned with the error.
+func (p *poolScrape) Datasets() ([]*ioctl.Dataset, error) {
+	if !p.datasetsFetched {
+		p.datasetsErr = p.listDatasets(p.name)
+		p.datasetsFetched = true
+	}
+	return p.datasets, p.datasetsErr
+}
+
+func (p *poolScrape) listDatasets(parent string) error {
+	for dataset, err := range p.zfs.DatasetIterator(p.ctx, parent) {
+		if errors.Is(err, ioctl.ErrDatasetNotFound) {
+			// The dataset was destroyed while walking its children.
+			slog.Debug("dataset vanished during collection", "dataset", parent, "error", err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p.datasets = append(p.datasets, dataset)
+		if err := p.listDatasets(dataset.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,658 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/nvlist"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"golang.org/x/sys/unix"
+)
+
+type fakeKey struct {
+	ioctl  ioctl.Ioctl
+	name   string
+	cookie uint64
+}
+
+type fakeResponse struct {
+	name   string
+	cookie uint64
+	resp   []byte
+	errno  unix.Errno
+}
+
+// fakeZFS is an in-memory ioctl.Handle. Ioctls without a response fail with ESRCH, responses with an errno
+// fail with a ZFSError.
+type fakeZFS map[fakeKey]fakeResponse
+
+func (f fakeZFS) Ioctl(ioc ioctl.Ioctl, cmd *ioctl.Cmd, request []byte, config []byte, resp *[]byte) error {
+	r, ok := f[fakeKey{ioc, cmd.GetName(), cmd.Cookie}]
+	if !ok {
+		return unix.ESRCH
+	}
+	if r.errno != 0 {
+		return &ioctl.ZFSError{Ioctl: ioc, Name: cmd.GetName(), Errno: r.errno}
+	}
+	if len(*resp) < len(r.resp) {
+		*resp = make([]byte, len(r.resp))
+	}
+	copy(*resp, r.resp)
+	cmd.Nvlist_dst_size = uint64(len(r.resp))
+	cmd.SetName(r.name)
+	cmd.Cookie = r.cookie
+	return nil
+}
+
+func endNvlist(t testing.TB, w *nvlist.NVListWriter) {
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newFakeZFS returns a fake with the pool "tank" consisting of a single disk and the datasets tank/a and
+// tank/a/b.
+func newFakeZFS(t testing.TB) fakeZFS {
+	stats := make([]uint64, 49)
+	for i := range stats {
+		stats[i] = uint64(100 + i)
+	}
+	stats[ioctl.VDevStats_vs_state] = ioctl.StateHealthy
+
+	configs := nvlist.NVListWriter{}
+	configs.BeginNvlist("tank")
+	configs.AddString("name", "tank")
+	configs.AddUInt64("pool_guid", 1234)
+	endNvlist(t, &configs)
+	endNvlist(t, &configs)
+
+	poolStats := nvlist.NVListWriter{}
+	poolStats.AddUInt64("state", 0)
+	poolStats.AddUInt64("error_count", 3)
+	poolStats.BeginNvlist("vdev_tree")
+	poolStats.AddString("type", "root")
+	poolStats.AddUInt64Array("vdev_stats", stats)
+	// A scrub that finished without errors.
+	poolStats.AddUInt64Array("scan_stats", []uint64{1, 2, 1700000000, 1700003600, 5000, 5000, 0, 0, 0})
+	poolStats.BeginNvlistArray("children", 1)
+	poolStats.AddString("type", "disk")
+	poolStats.AddString("path", "/dev/sda1")
+	poolStats.AddUInt64Array("vdev_stats", stats)
+	endNvlist(t, &poolStats) // ends the children array with its only element
+	endNvlist(t, &poolStats)
+	endNvlist(t, &poolStats)
+
+	poolProps := nvlist.NVListWriter{}
+	for name, value := range map[string]uint64{"size": 1 << 30, "allocated": 1 << 20, "dedupratio": 250,
+		"fragmentation": math.MaxUint64, "failmode": 1, "autotrim": 1, "autoexpand": 0} {
+		poolProps.BeginNvlist(name)
+		poolProps.AddUInt64("value", value)
+		poolProps.AddUInt64("source", 2)
+		endNvlist(t, &poolProps)
+	}
+	poolProps.BeginNvlist("comment")
+	poolProps.AddString("value", "backup pool")
+	poolProps.AddUInt64("source", 8)
+	endNvlist(t, &poolProps)
+	endNvlist(t, &poolProps)
+
+	props := func(objsetid uint64, used uint64) []byte {
+		w := nvlist.NVListWriter{}
+		for name, value := range map[string]uint64{"objsetid": objsetid, "used": used, "compressratio": 150} {
+			w.BeginNvlist(name)
+			w.AddUInt64("value", value)
+			endNvlist(t, &w)
+		}
+		endNvlist(t, &w)
+		return w.Data
+	}
+
+	return fakeZFS{
+		{ioctl.ZFS_IOC_POOL_CONFIGS, "", 0}:            {resp: configs.Data},
+		{ioctl.ZFS_IOC_POOL_STATS, "tank", 0}:          {name: "tank", resp: poolStats.Data},
+		{ioctl.ZFS_IOC_POOL_GET_PROPS, "tank", 0}:      {name: "tank", resp: poolProps.Data},
+		{ioctl.ZFS_IOC_DATASET_LIST_NEXT, "tank", 0}:   {name: "tank/a", cookie: 7, resp: props(0x36, 1000)},
+		{ioctl.ZFS_IOC_DATASET_LIST_NEXT, "tank/a", 0}: {name: "tank/a/b", cookie: 9, resp: props(0x37, 2000)},
+	}
+}
+
+// withoutTimings removes the collector durations and the collection timestamp from metrics in the text format.
+func withoutTimings(metrics string) string {
+	var lines []string
+	for _, line := range strings.SplitAfter(metrics, "\n") {
+		if !strings.HasPrefix(line, "zfs_exporter_collector_duration_seconds{") &&
+			!strings.HasPrefix(line, "zfs_exporter_last_collection_timestamp_seconds ") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// gather collects the metrics of c and returns them in the text format.
+func gather(t *testing.T, c prometheus.Collector) string {
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.String()
+}
+
+func TestCollectRecordReplay(t *testing.T) {
+	kstatPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(kstatPath, "tank"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	kstat := "1 1 0x01 7 2160 1 2\nname                            type data\ndataset_name                    7    tank/a\nwrites                          4    42\n"
+	if err := os.WriteFile(filepath.Join(kstatPath, "tank", "objset-0x36"), []byte(kstat), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	recorded := gather(t, &zfsCollector{
+		zfs:            &ioctl.Client{Handle: &ioctl.Recorder{Handle: newFakeZFS(t), Dir: dir}},
+		kstatPath:      kstatPath,
+		kstatRecordDir: filepath.Join(dir, "kstat"),
+	})
+	replayed := gather(t, &zfsCollector{
+		zfs:       &ioctl.Client{Handle: &ioctl.ReplayHandle{Dir: dir}},
+		kstatPath: filepath.Join(dir, "kstat"),
+	})
+
+	for _, want := range []string{
+		`zfs_pool_error_count{pool="tank"} 3`,
+		`zfs_pool_state{pool="tank",state="ACTIVE"} 1`,
+		`zfs_pool_vdev_alloc_space{pool="tank",vdev="tank/sda1",vdev_type="disk"} 103`,
+		`zfs_pool_scan_state{function="SCRUB",pool="tank",state="FINISHED"} 1`,
+		`zfs_pool_scan_examined_bytes{function="SCRUB",pool="tank"} 5000`,
+		`zfs_pool_last_scrub_completed_timestamp_seconds{pool="tank"} 1.7000036e+09`,
+		`zfs_dataset_used{name="tank/a",pool="tank"} 1000`,
+		`zfs_dataset_used{name="tank/a/b",pool="tank"} 2000`,
+		`zfs_dataset_writes{name="tank/a",pool="tank"} 42`,
+	} {
+		if !strings.Contains(recorded, want+"\n") {
+			t.Errorf("metric %s missing in:\n%s", want, recorded)
+		}
+	}
+	// The durations and time of the collections differ between the runs.
+	if withoutTimings(replayed) != withoutTimings(recorded) {
+		t.Errorf("replayed metrics differ from recorded ones:\n%s\nrecorded:\n%s", replayed, recorded)
+	}
+}
+
+func TestReplayResize(t *testing.T) {
+	dir := t.TempDir()
+	recorder := &ioctl.Recorder{Handle: newFakeZFS(t), Dir: dir}
+	cmd := ioctl.Cmd{}
+	cmd.SetName("tank")
+	resp := make([]byte, 1024)
+	if err := recorder.Ioctl(ioctl.ZFS_IOC_POOL_STATS, &cmd, nil, nil, &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := resp[:cmd.Nvlist_dst_size]
+
+	// The response does not fit into 16 bytes, the replay has to fail with ENOMEM and the buffer be resized.
+	cmd = ioctl.Cmd{}
+	cmd.SetName("tank")
+	resp = make([]byte, 16)
+	if err := (&ioctl.ReplayHandle{Dir: dir}).Ioctl(ioctl.ZFS_IOC_POOL_STATS, &cmd, nil, nil, &resp); err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	if !bytes.Equal(resp, want) {
+		t.Errorf("replayed response differs from recorded one")
+	}
+
+	cmd = ioctl.Cmd{}
+	cmd.SetName("tank/missing")
+	err := (&ioctl.ReplayHandle{Dir: dir}).Ioctl(ioctl.ZFS_IOC_DATASET_LIST_NEXT, &cmd, nil, nil, &resp)
+	if !errors.Is(err, unix.ESRCH) {
+		t.Errorf("expected ESRCH for unrecorded dataset iteration, got %v", err)
+	}
+}
+
+func TestVanishedObjects(t *testing.T) {
+	zfs := newFakeZFS(t)
+	configs := nvlist.NVListWriter{}
+	for _, name := range []string{"gone", "tank"} {
+		configs.BeginNvlist(name)
+		configs.AddString("name", name)
+		endNvlist(t, &configs)
+	}
+	endNvlist(t, &configs)
+	zfs[fakeKey{ioctl.ZFS_IOC_POOL_CONFIGS, "", 0}] = fakeResponse{resp: configs.Data}
+	// The pool gone was exported and tank/a destroyed after they were listed.
+	zfs[fakeKey{ioctl.ZFS_IOC_POOL_STATS, "gone", 0}] = fakeResponse{errno: unix.ENOENT}
+	zfs[fakeKey{ioctl.ZFS_IOC_DATASET_LIST_NEXT, "tank/a", 0}] = fakeResponse{errno: unix.ENOENT}
+
+	c := &zfsCollector{zfs: &ioctl.Client{Handle: zfs}, kstatPath: t.TempDir()}
+	c.describe(nil)
+	if err := c.collect(nil); err != nil {
+		t.Fatalf("collect() failed: %v", err)
+	}
+	got := gather(t, c)
+	if !strings.Contains(got, `zfs_dataset_used{name="tank/a",pool="tank"} 1000`+"\n") {
+		t.Errorf("metrics of tank missing in:\n%s", got)
+	}
+	if strings.Contains(got, `pool="gone"`) {
+		t.Errorf("unexpected metrics of vanished pool in:\n%s", got)
+	}
+
+	// Other errors are reported as failed collectors of the pool.
+	zfs[fakeKey{ioctl.ZFS_IOC_POOL_STATS, "gone", 0}] = fakeResponse{errno: unix.EIO}
+	got = gather(t, c)
+	for _, want := range []string{
+		`zfs_dataset_used{name="tank/a",pool="tank"} 1000`,
+		`zfs_exporter_collector_success{collector="pool",pool="gone"} 0`,
+		`zfs_exporter_collector_success{collector="vdev",pool="gone"} 0`,
+		`zfs_exporter_errors_total{collector="pool",reason="ioctl"} 1`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("%s missing in:\n%s", want, got)
+		}
+	}
+}
+
+func TestPartialFailure(t *testing.T) {
+	kstatPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(kstatPath, "tank"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// The kstat of tank/a is truncated, the one of tank/a/b is fine.
+	if err := os.WriteFile(filepath.Join(kstatPath, "tank", "objset-0x36"), []byte("1 1 0x01 7 2160 1 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	kstat := "1 1 0x01 7 2160 1 2\nname                            type data\ndataset_name                    7    tank/a/b\nwrites                          4    42\n"
+	if err := os.WriteFile(filepath.Join(kstatPath, "tank", "objset-0x37"), []byte(kstat), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := gather(t, &zfsCollector{zfs: &ioctl.Client{Handle: newFakeZFS(t)}, kstatPath: kstatPath})
+	for _, want := range []string{
+		`zfs_pool_state{pool="tank",state="ACTIVE"} 1`,
+		`zfs_dataset_used{name="tank/a",pool="tank"} 1000`,
+		`zfs_dataset_writes{name="tank/a/b",pool="tank"} 42`,
+		`zfs_exporter_collector_success{collector="pool",pool="tank"} 1`,
+		`zfs_exporter_collector_success{collector="vdev",pool="tank"} 1`,
+		`zfs_exporter_collector_success{collector="dataset",pool="tank"} 1`,
+		`zfs_exporter_collector_success{collector="dataset-kstat",pool="tank"} 0`,
+		`zfs_exporter_errors_total{collector="dataset-kstat",reason="parse"} 1`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("%s missing in:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `zfs_dataset_writes{name="tank/a",`) {
+		t.Errorf("unexpected kstats of tank/a in:\n%s", got)
+	}
+}
+
+func TestPartialDatasetList(t *testing.T) {
+	kstatPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(kstatPath, "tank"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	kstat := "1 1 0x01 7 2160 1 2\nname                            type data\ndataset_name                    7    tank/a\nwrites                          4    42\n"
+	if err := os.WriteFile(filepath.Join(kstatPath, "tank", "objset-0x36"), []byte(kstat), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Listing the children of tank/a fails after tank/a was listed.
+	zfs := newFakeZFS(t)
+	zfs[fakeKey{ioctl.ZFS_IOC_DATASET_LIST_NEXT, "tank/a", 0}] = fakeResponse{errno: unix.EIO}
+
+	got := gather(t, &zfsCollector{zfs: &ioctl.Client{Handle: zfs}, kstatPath: kstatPath})
+	for _, want := range []string{
+		`zfs_dataset_used{name="tank/a",pool="tank"} 1000`,
+		`zfs_dataset_writes{name="tank/a",pool="tank"} 42`,
+		`zfs_exporter_collector_success{collector="dataset",pool="tank"} 0`,
+		`zfs_exporter_collector_success{collector="dataset-kstat",pool="tank"} 0`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("%s missing in:\n%s", want, got)
+		}
+	}
+}
+
+func TestZFSUnavailable(t *testing.T) {
+	var zfs ioctl.Handle
+	h := &ioctl.ReopeningHandle{Open: func() (ioctl.Handle, error) {
+		if zfs == nil {
+			return nil, os.ErrNotExist
+		}
+		return zfs, nil
+	}}
+	c := &zfsCollector{zfs: &ioctl.Client{Handle: h}, kstatPath: t.TempDir()}
+
+	got := gather(t, c)
+	if !strings.Contains(got, "zfs_up 0\n") {
+		t.Errorf("zfs_up 0 missing in:\n%s", got)
+	}
+
+	// The kernel module was loaded after the exporter started.
+	zfs = newFakeZFS(t)
+	got = gather(t, c)
+	if !strings.Contains(got, "zfs_up 1\n") || !strings.Contains(got, `zfs_pool_error_count{pool="tank"} 3`+"\n") {
+		t.Errorf("metrics missing after zfs became available:\n%s", got)
+	}
+}
+
+// stallingHandle blocks the ioctl stall until release is closed and passes all other ioctls to Handle.
+type stallingHandle struct {
+	ioctl.Handle
+	stall   ioctl.Ioctl
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (h *stallingHandle) Ioctl(ioc ioctl.Ioctl, cmd *ioctl.Cmd, request []byte, config []byte, resp *[]byte) error {
+	if ioc == h.stall {
+		h.calls.Add(1)
+		<-h.release
+	}
+	return h.Handle.Ioctl(ioc, cmd, request, config, resp)
+}
+
+func TestStalledPool(t *testing.T) {
+	h := &stallingHandle{Handle: newFakeZFS(t), stall: ioctl.ZFS_IOC_POOL_STATS, release: make(chan struct{})}
+	c := &zfsCollector{zfs: &ioctl.Client{Handle: h, Timeout: 10 * time.Millisecond}, kstatPath: t.TempDir()}
+
+	// While the ioctl is blocked the pool is skipped, without issuing further ioctls.
+	for range 2 {
+		got := gather(t, c)
+		if !strings.Contains(got, `zfs_pool_collection_stalled{pool="tank"} 1`+"\n") {
+			t.Errorf("stalled pool not reported in:\n%s", got)
+		}
+		if strings.Contains(got, "zfs_dataset_used") {
+			t.Errorf("unexpected metrics of stalled pool in:\n%s", got)
+		}
+	}
+	if calls := h.calls.Load(); calls != 1 {
+		t.Errorf("got %d ZFS_IOC_POOL_STATS calls, want 1", calls)
+	}
+
+	close(h.release)
+	done := c.stalled["tank"]
+	<-done
+	got := gather(t, c)
+	if !strings.Contains(got, `zfs_pool_collection_stalled{pool="tank"} 0`+"\n") ||
+		!strings.Contains(got, `zfs_dataset_used{name="tank/a",pool="tank"} 1000`+"\n") {
+		t.Errorf("pool not collected after the ioctl returned:\n%s", got)
+	}
+}
+
+// slowHandle delays every ioctl by delay before passing it to Handle.
+type slowHandle struct {
+	ioctl.Handle
+	delay time.Duration
+}
+
+func (h *slowHandle) Ioctl(ioc ioctl.Ioctl, cmd *ioctl.Cmd, request []byte, config []byte, resp *[]byte) error {
+	time.Sleep(h.delay)
+	return h.Handle.Ioctl(ioc, cmd, request, config, resp)
+}
+
+func TestSlowPoolNotStalled(t *testing.T) {
+	// tank has 100 datasets, each ioctl is fast but listing them takes longer than the timeout.
+	zfs := newFakeZFS(t)
+	props := zfs[fakeKey{ioctl.ZFS_IOC_DATASET_LIST_NEXT, "tank", 0}].resp
+	delete(zfs, fakeKey{ioctl.ZFS_IOC_DATASET_LIST_NEXT, "tank/a", 0})
+	const datasets = 100
+	for i := range uint64(datasets) {
+		zfs[fakeKey{ioctl.ZFS_IOC_DATASET_LIST_NEXT, "tank", i}] = fakeResponse{name: fmt.Sprintf("tank/d%d", i), cookie: i + 1, resp: props}
+	}
+	h := &slowHandle{Handle: zfs, delay: time.Millisecond}
+	c := &zfsCollector{zfs: &ioctl.Client{Handle: h, Timeout: 50 * time.Millisecond}, kstatPath: t.TempDir()}
+
+	start := time.Now()
+	got := gather(t, c)
+	if elapsed := time.Since(start); elapsed < c.zfs.Timeout {
+		t.Fatalf("collection took %v, want longer than the timeout", elapsed)
+	}
+	if !strings.Contains(got, `zfs_pool_collection_stalled{pool="tank"} 0`+"\n") {
+		t.Errorf("slow pool reported as stalled in:\n%s", got)
+	}
+	if n := strings.Count(got, "zfs_dataset_used{"); n != datasets {
+		t.Errorf("got %d datasets, want %d", n, datasets)
+	}
+}
+
+func TestStalledPoolConfigs(t *testing.T) {
+	h := &stallingHandle{Handle: newFakeZFS(t), stall: ioctl.ZFS_IOC_POOL_CONFIGS, release: make(chan struct{})}
+	c := &zfsCollector{zfs: &ioctl.Client{Handle: h, Timeout: 10 * time.Millisecond}, kstatPath: t.TempDir()}
+
+	// While the ioctl is blocked, the pools are not listed again.
+	for range 2 {
+		got := gather(t, c)
+		if !strings.Contains(got, `zfs_exporter_collector_success{collector="pool-configs",pool=""} 0`+"\n") {
+			t.Errorf("failed listing of the pools not reported in:\n%s", got)
+		}
+	}
+	if calls := h.calls.Load(); calls != 1 {
+		t.Errorf("got %d ZFS_IOC_POOL_CONFIGS calls, want 1", calls)
+	}
+
+	close(h.release)
+	done, ok := c.stalled[poolConfigsStalled]
+	if !ok {
+		t.Fatal("stalled listing of the pools not recorded")
+	}
+	<-done
+	got := gather(t, c)
+	if !strings.Contains(got, `zfs_pool_error_count{pool="tank"} 3`+"\n") {
+		t.Errorf("pools not collected after the ioctl returned:\n%s", got)
+	}
+}
+
+func TestPoolProps(t *testing.T) {
+	got := gather(t, &zfsCollector{zfs: &ioctl.Client{Handle: newFakeZFS(t)}, kstatPath: t.TempDir()})
+	for _, want := range []string{
+		`zfs_pool_size{pool="tank"} 1.073741824e+09`,
+		`zfs_pool_allocated{pool="tank"} 1.048576e+06`,
+		`zfs_pool_dedup_ratio{pool="tank"} 2.5`,
+		`zfs_pool_info{altroot="",autoexpand="off",autotrim="on",comment="backup pool",failmode="continue",pool="tank"} 1`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("missing metric %s", want)
+		}
+	}
+	// Unavailable and unknown properties are not exported.
+	for _, name := range []string{"zfs_pool_fragmentation", "zfs_pool_bclone_used"} {
+		if strings.Contains(got, name+"{") {
+			t.Errorf("unexpected metric %s", name)
+		}
+	}
+}
+
+func TestLastScrubAfterResilver(t *testing.T) {
+	zfs := newFakeZFS(t)
+	c := &isolatedCollector{collector: newPoolCollector(collectorConfig{}), t: t, zfs: zfs}
+	want := `zfs_pool_last_scrub_completed_timestamp_seconds{pool="tank"} 1.7000036e+09` + "\n"
+	if got := gather(t, c); !strings.Contains(got, want) {
+		t.Fatalf("%s missing after the scrub in:\n%s", want, got)
+	}
+
+	// A resilver replaces the stats of the scrub.
+	poolStats := nvlist.NVListWriter{}
+	poolStats.AddUInt64("state", 0)
+	poolStats.BeginNvlist("vdev_tree")
+	poolStats.AddString("type", "root")
+	poolStats.AddUInt64Array("scan_stats", []uint64{2, 2, 1700100000, 1700103600, 5000, 5000, 0, 0, 0})
+	endNvlist(t, &poolStats)
+	endNvlist(t, &poolStats)
+	zfs[fakeKey{ioctl.ZFS_IOC_POOL_STATS, "tank", 0}] = fakeResponse{name: "tank", resp: poolStats.Data}
+
+	got := gather(t, c)
+	if !strings.Contains(got, `zfs_pool_scan_state{function="RESILVER",pool="tank",state="FINISHED"} 1`+"\n") {
+		t.Errorf("resilver missing in:\n%s", got)
+	}
+	if !strings.Contains(got, want) {
+		t.Errorf("%s missing after the resilver in:\n%s", want, got)
+	}
+}
+
+// isolatedCollector runs a single Collector for the pool tank, or a single SystemCollector, without the
+// metrics of zfsCollector.
+type isolatedCollector struct {
+	collector       Collector
+	systemCollector SystemCollector
+	t               *testing.T
+	zfs             ioctl.Handle
+}
+
+func (c *isolatedCollector) Describe(ch chan<- *prometheus.Desc) {
+	if c.systemCollector != nil {
+		c.systemCollector.Describe(&ch)
+	} else {
+		c.collector.Describe(&ch)
+	}
+}
+
+func (c *isolatedCollector) Collect(ch chan<- prometheus.Metric) {
+	zfs := &ioctl.Client{Handle: c.zfs}
+	var err error
+	if c.systemCollector != nil {
+		var configs []ioctl.PoolConfig
+		if configs, err = zfs.PoolConfigs(context.Background()); err == nil {
+			err = c.systemCollector.Update(&ch, configs)
+		}
+	} else {
+		err = c.collector.Update(&ch, newPoolScrape(context.Background(), zfs, "tank"))
+	}
+	if err != nil {
+		c.t.Errorf("Update() failed: %v", err)
+	}
+}
+
+func TestCollectorsInIsolation(t *testing.T) {
+	kstatPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(kstatPath, "tank"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	kstat := "1 1 0x01 7 2160 1 2\nname                            type data\ndataset_name                    7    tank/a\nwrites                          4    42\n"
+	if err := os.WriteFile(filepath.Join(kstatPath, "tank", "objset-0x36"), []byte(kstat), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config := collectorConfig{readKStat: (&zfsCollector{kstatPath: kstatPath}).readKStat, zpoolCachePath: writeZpoolCache(t)}
+
+	// metrics holds a metric exported by each collector.
+	metrics := map[string]string{
+		"pool":          `zfs_pool_error_count{pool="tank"} 3`,
+		"vdev":          `zfs_pool_vdev_alloc_space{pool="tank",vdev="tank/sda1",vdev_type="disk"} 103`,
+		"dataset":       `zfs_dataset_used{name="tank/a/b",pool="tank"} 2000`,
+		"dataset-kstat": `zfs_dataset_writes{name="tank/a",pool="tank"} 42`,
+		"zpool-cache":   `zfs_pool_cached_not_imported{guid="5678",pool="old"} 1`,
+	}
+	if len(metrics) != len(registeredCollectors) {
+		t.Errorf("got %d collectors, want %d", len(registeredCollectors), len(metrics))
+	}
+	for _, r := range registeredCollectors {
+		c := &isolatedCollector{t: t, zfs: newFakeZFS(t)}
+		if r.systemFactory != nil {
+			c.systemCollector = r.systemFactory(config)
+		} else {
+			c.collector = r.factory(config)
+		}
+		got := gather(t, c)
+		for name, metric := range metrics {
+			if strings.Contains(got, metric+"\n") != (name == r.name) {
+				t.Errorf("%s: got metrics\n%s\nwant only %s", r.name, got, metrics[r.name])
+			}
+		}
+	}
+}
+
+func TestCollectorFlags(t *testing.T) {
+	for _, name := range []string{"collector.vdev", "no-collector.dataset-kstat", "no-collector.zpool-cache"} {
+		defer flag.Set(name, flag.Lookup(name).DefValue)
+	}
+	if got, want := enabledCollectorNames(), []string{"pool", "vdev", "dataset", "dataset-kstat", "zpool-cache"}; !slices.Equal(got, want) {
+		t.Errorf("got enabled collectors %v, want %v", got, want)
+	}
+
+	flag.Set("collector.vdev", "false")
+	flag.Set("no-collector.dataset-kstat", "true")
+	flag.Set("no-collector.zpool-cache", "true")
+	names := enabledCollectorNames()
+	if want := []string{"pool", "dataset"}; !slices.Equal(names, want) {
+		t.Errorf("got enabled collectors %v, want %v", names, want)
+	}
+
+	got := gather(t, &zfsCollector{zfs: &ioctl.Client{Handle: newFakeZFS(t)}, kstatPath: t.TempDir(), zpoolCachePath: writeZpoolCache(t), collectorNames: names})
+	if !strings.Contains(got, `zfs_exporter_collector_success{collector="dataset",pool="tank"} 1`+"\n") ||
+		strings.Contains(got, `collector="vdev"`) || strings.Contains(got, "zfs_pool_vdev_") || strings.Contains(got, "zfs_dataset_writes") ||
+		strings.Contains(got, `collector="zpool-cache"`) || strings.Contains(got, "zfs_pool_cache") {
+		t.Errorf("unexpected metrics with the vdev, dataset-kstat and zpool-cache collectors disabled:\n%s", got)
+	}
+}
+
+func TestConcurrentScrapesShareCollection(t *testing.T) {
+	h := &stallingHandle{Handle: newFakeZFS(t), stall: ioctl.ZFS_IOC_POOL_STATS, release: make(chan struct{})}
+	c := &zfsCollector{zfs: &ioctl.Client{Handle: h}, kstatPath: t.TempDir()}
+	c.describe(nil)
+
+	snapshots := make(chan *snapshot)
+	for range 2 {
+		go func() { snapshots <- c.collectSnapshot() }()
+	}
+	// Let the second scrape arrive while the first one is blocked in ZFS_IOC_POOL_STATS.
+	for h.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(h.release)
+
+	if first, second := <-snapshots, <-snapshots; first != second {
+		t.Errorf("concurrent scrapes got different snapshots")
+	}
+	if calls := h.calls.Load(); calls != 1 {
+		t.Errorf("got %d ZFS_IOC_POOL_STATS calls, want 1", calls)
+	}
+}
+
+func TestBackgroundCollection(t *testing.T) {
+	h := &stallingHandle{Handle: newFakeZFS(t), stall: ioctl.ZFS_IOC_POOL_STATS, release: make(chan struct{})}
+	close(h.release)
+	c := &zfsCollector{zfs: &ioctl.Client{Handle: h}, kstatPath: t.TempDir()}
+	c.describe(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.collectEvery(ctx, time.Hour)
+	for c.latest.Load() == nil {
+		time.Sleep(time.Millisecond)
+	}
+	timestamp := fmt.Sprintf("zfs_exporter_last_collection_timestamp_seconds %g\n",
+		float64(c.latest.Load().timestamp.UnixNano())/float64(time.Second))
+
+	// Scrapes are served from the snapshot without issuing ioctls.
+	for range 2 {
+		got := gather(t, c)
+		if !strings.Contains(got, `zfs_dataset_used{name="tank/a",pool="tank"} 1000`+"\n") || !strings.Contains(got, timestamp) {
+			t.Errorf("snapshot not served, got:\n%s", got)
+		}
+	}
+	if calls := h.calls.Load(); calls != 1 {
+		t.Errorf("got %d ZFS_IOC_POOL_STATS calls, want 1", calls)
+	}
+}
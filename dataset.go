@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// datasetCollector exports the space usage of all datasets of a pool.
+type datasetCollector struct {
+	datasetAvailable            *prometheus.Desc
+	datasetCompressRatio        *prometheus.Desc
+	datasetUsed                 *prometheus.Desc
+	datasetUsedByChildren       *prometheus.Desc
+	datasetUsedByDataset        *prometheus.Desc
+	datasetUsedByRefReservation *prometheus.Desc
+	datasetUsedBySnapshots      *prometheus.Desc
+	datasetReferenced           *prometheus.Desc
+	datasetRefCompressRatio     *prometheus.Desc
+	datasetLogicalReferenced    *prometheus.Desc
+	datasetLogicalUsed          *prometheus.Desc
+}
+
+func newDatasetCollector(config collectorConfig) Collector {
+	return &datasetCollector{}
+}
+
+func (c *datasetCollector) Describe(ch *chan<- *prometheus.Desc) {
+	describe(ch, &c.datasetAvailable, prometheus.NewDesc("zfs_dataset_available", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetCompressRatio, prometheus.NewDesc("zfs_dataset_compress_ratio", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetUsed, prometheus.NewDesc("zfs_dataset_used", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetUsedByChildren, prometheus.NewDesc("zfs_dataset_used_by_children", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetUsedByDataset, prometheus.NewDesc("zfs_dataset_used_by_dataset", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetUsedByRefReservation, prometheus.NewDesc("zfs_dataset_used_by_ref_reservation", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetUsedBySnapshots, prometheus.NewDesc("zfs_dataset_used_by_snapshots", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetReferenced, prometheus.NewDesc("zfs_dataset_referenced", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetRefCompressRatio, prometheus.NewDesc("zfs_dataset_ref_compress_ratio", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetLogicalReferenced, prometheus.NewDesc("zfs_dataset_logical_referenced", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetLogicalUsed, prometheus.NewDesc("zfs_dataset_logical_used", "", []string{"name", "pool"}, nil))
+}
+
+func (c *datasetCollector) Update(ch *chan<- prometheus.Metric, pool *poolScrape) error {
+	// The datasets listed before an error are still exported.
+	datasets, listErr := pool.Datasets()
+	for _, dataset := range datasets {
+		if err := c.handleDataset(ch, pool.name, dataset); err != nil {
+			return err
+		}
+	}
+	return listErr
+}
+
+func (c *datasetCollector) handleDataset(ch *chan<- prometheus.Metric, pool string, dataset *ioctl.Dataset) error {
+	labels := []string{dataset.Name, pool}
+	props := &dataset.Props
+
+	if err := export(ch, c.datasetAvailable, prometheus.GaugeValue, float64(props.Available), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetCompressRatio, prometheus.GaugeValue, float64(props.CompressRatio)/100, labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetUsed, prometheus.GaugeValue, float64(props.Used), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetUsedByChildren, prometheus.GaugeValue, float64(props.UsedByChildren), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetUsedByDataset, prometheus.GaugeValue, float64(props.UsedByDataset), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetUsedByRefReservation, prometheus.GaugeValue, float64(props.UsedByRefReservation), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetUsedBySnapshots, prometheus.GaugeValue, float64(props.UsedBySnapshots), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetReferenced, prometheus.GaugeValue, float64(props.Referenced), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetRefCompressRatio, prometheus.GaugeValue, float64(props.RefCompressRatio)/100, labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetLogicalReferenced, prometheus.GaugeValue, float64(props.LogicalReferenced), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetLogicalUsed, prometheus.GaugeValue, float64(props.LogicalUsed), labels); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/kstat"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// datasetKStatCollector exports the I/O counters of all datasets of a pool from their objset kstats.
+type datasetKStatCollector struct {
+	// readKStat reads the kstat name of a pool.
+	readKStat func(pool string, name string) ([]byte, error)
+
+	datasetWrites    *prometheus.Desc
+	datasetNWritten  *prometheus.Desc
+	datasetReads     *prometheus.Desc
+	datasetNRead     *prometheus.Desc
+	datasetUnlinks   *prometheus.Desc
+	datasetNUnlinked *prometheus.Desc
+}
+
+func newDatasetKStatCollector(config collectorConfig) Collector {
+	return &datasetKStatCollector{readKStat: config.readKStat}
+}
+
+func (c *datasetKStatCollector) Describe(ch *chan<- *prometheus.Desc) {
+	describe(ch, &c.datasetWrites, prometheus.NewDesc("zfs_dataset_writes", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetNWritten, prometheus.NewDesc("zfs_dataset_nwritten", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetReads, prometheus.NewDesc("zfs_dataset_reads", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetNRead, prometheus.NewDesc("zfs_dataset_nread", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetUnlinks, prometheus.NewDesc("zfs_dataset_nunlinks", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.datasetNUnlinked, prometheus.NewDesc("zfs_dataset_nunlinked", "", []string{"name", "pool"}, nil))
+}
+
+// Update exports the kstats of all datasets. A dataset whose kstat can not be read does not stop the others
+// from being exported, the errors of all datasets are returned joined. If listing the datasets fails, the
+// kstats of the datasets listed until then are still exported.
+func (c *datasetKStatCollector) Update(ch *chan<- prometheus.Metric, pool *poolScrape) error {
+	datasets, listErr := pool.Datasets()
+	errs := []error{listErr}
+	for _, dataset := range datasets {
+		kstats, err := c.readDatasetKStats(pool.name, dataset)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := c.exportDatasetKStats(ch, pool.name, dataset, kstats); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readDatasetKStats reads the objset kstat of a dataset. Datasets without kstat, e.g. because they are not
+// mounted, have zero counters.
+func (c *datasetKStatCollector) readDatasetKStats(pool string, dataset *ioctl.Dataset) (*datasetKStats, error) {
+	kstats := &datasetKStats{}
+	kstatData, err := c.readKStat(pool, fmt.Sprintf("objset-0x%x", dataset.Props.ObjsetID))
+	if err != nil {
+		// Either kstats not supported or dataset not mounted...
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading kstats for %q (objset %v): %w", dataset.Name, dataset.Props.ObjsetID, err)
+		}
+		return kstats, nil
+	}
+	r := kstat.KStatReader{
+		Data: kstatData,
+	}
+	if err := kstats.parseKStat(&r); err != nil {
+		return nil, fmt.Errorf("error parsing kstats for %q (objset %v): %w", dataset.Name, dataset.Props.ObjsetID, err)
+	}
+	return kstats, nil
+}
+
+func (c *datasetKStatCollector) exportDatasetKStats(ch *chan<- prometheus.Metric, pool string, dataset *ioctl.Dataset, kstats *datasetKStats) error {
+	labels := []string{dataset.Name, pool}
+
+	if err := export(ch, c.datasetWrites, prometheus.CounterValue, float64(kstats.writes), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetNWritten, prometheus.CounterValue, float64(kstats.nwritten), labels); err != nil {
+		return err
+	}
+
+	if err := export(ch, c.datasetReads, prometheus.CounterValue, float64(kstats.reads), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetNRead, prometheus.CounterValue, float64(kstats.nread), labels); err != nil {
+		return err
+	}
+
+	if err := export(ch, c.datasetUnlinks, prometheus.CounterValue, float64(kstats.nunlinks), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.datasetNUnlinked, prometheus.CounterValue, float64(kstats.nunlinked), labels); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// datasetKStats holds the I/O statistics of a mounted dataset, read from its objset kstat.
+type datasetKStats struct {
+	writes    uint64
+	nwritten  uint64
+	reads     uint64
+	nread     uint64
+	nunlinks  uint64
+	nunlinked uint64
+}
+
+func (d *datasetKStats) parseKStat(r *kstat.KStatReader) error {
+	for {
+		name, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		switch name {
+		case "writes":
+			d.writes, err = r.RowDataAsUInt64()
+			if err != nil {
+				return fmt.Errorf("error reading \"writes\" row: %w", err)
+			}
+		case "nwritten":
+			d.nwritten, err = r.RowDataAsUInt64()
+			if err != nil {
+				return fmt.Errorf("error reading \"nwritten\" row: %w", err)
+			}
+		case "reads":
+			d.reads, err = r.RowDataAsUInt64()
+			if err != nil {
+				return fmt.Errorf("error reading \"reads\" row: %w", err)
+			}
+		case "nread":
+			d.nread, err = r.RowDataAsUInt64()
+			if err != nil {
+				return fmt.Errorf("error reading \"nread\" row: %w", err)
+			}
+		case "nunlinks":
+			d.nunlinks, err = r.RowDataAsUInt64()
+			if err != nil {
+				return fmt.Errorf("error reading \"nunlinks\" row: %w", err)
+			}
+		case "nunlinked":
+			d.nunlinked, err = r.RowDataAsUInt64()
+			if err != nil {
+				return fmt.Errorf("error reading \"nunlinked\" row: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
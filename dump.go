@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/nvlist"
+)
+
+var errDumpUsage = errors.New("usage: prometheus-zfs-exporter dump pool-configs|pool-stats <pool>|pool-props <pool>|dataset <name>")
+
+// dump runs the ioctl selected by args and prints the nvlist returned by the kernel as JSON, which helps to
+// debug metrics that look wrong.
+func dump(args []string) error {
+	if len(args) == 0 {
+		return errDumpUsage
+	}
+
+	var ioc ioctl.Ioctl
+	cmd := ioctl.Cmd{}
+	switch args[0] {
+	case "pool-configs":
+		if len(args) != 1 {
+			return errDumpUsage
+		}
+		ioc = ioctl.ZFS_IOC_POOL_CONFIGS
+	case "pool-stats", "pool-props", "dataset":
+		if len(args) != 2 {
+			return errDumpUsage
+		}
+		switch args[0] {
+		case "pool-stats":
+			ioc = ioctl.ZFS_IOC_POOL_STATS
+		case "pool-props":
+			ioc = ioctl.ZFS_IOC_POOL_GET_PROPS
+		case "dataset":
+			ioc = ioctl.ZFS_IOC_OBJSET_STATS
+		}
+		if err := cmd.SetName(args[1]); err != nil {
+			return err
+		}
+	default:
+		return errDumpUsage
+	}
+
+	zfsHandle, err := newHandle()
+	if err != nil {
+		return err
+	}
+	resp := make([]byte, 256*1024)
+	if err := zfsHandle.Ioctl(ioc, &cmd, nil, nil, &resp); err != nil {
+		return fmt.Errorf("error calling %s: %w", args[0], err)
+	}
+
+	return nvlist.ToJSON(os.Stdout, resp, "  ")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errorReason returns the reason label of zfs_exporter_errors_total for err. Errors that are neither returned
+// by ioctls nor by reading files are returned by decoding nvlists and kstats.
+func errorReason(err error) string {
+	var zfsErr *ioctl.ZFSError
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ioctl.ErrPermission), errors.Is(err, fs.ErrPermission):
+		return "permission"
+	case errors.Is(err, ioctl.ErrPoolSuspended):
+		return "suspended"
+	case errors.Is(err, ioctl.ErrUnavailable):
+		return "unavailable"
+	case errors.As(err, &zfsErr):
+		return "ioctl"
+	case errors.As(err, &pathErr):
+		return "read"
+	}
+	return "parse"
+}
+
+// abortsPool returns whether err ends the collection of a pool, because the pool vanished or its ioctls
+// stalled.
+func abortsPool(err error) bool {
+	var stalled *ioctl.StalledError
+	return errors.Is(err, ioctl.ErrPoolNotFound) || errors.As(err, &stalled)
+}
+
+// collectorDone exports whether collector succeeded for pool and how long it took. A failure is logged and
+// counted by its reason. pool is empty for collectors that are not run per pool.
+func (c *zfsCollector) collectorDone(ch *chan<- prometheus.Metric, collector string, pool string, duration time.Duration, err error) error {
+	success := 1.0
+	if err != nil {
+		slog.Error("error collecting zfs metrics", "collector", collector, "pool", pool, "error", err)
+		// Collectors that continue after errors return them joined, each is counted on its own.
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for _, err := range errs {
+			c.errorsTotal.WithLabelValues(collector, errorReason(err)).Inc()
+		}
+		success = 0
+	}
+	labels := []string{collector, pool}
+	if err := export(ch, c.collectorSuccess, prometheus.GaugeValue, success, labels); err != nil {
+		return err
+	}
+	return export(ch, c.collectorDuration, prometheus.GaugeValue, duration.Seconds(), labels)
+}
+
+// runCollector runs collect for collector and exports its health with collectorDone. The error of collect is
+// only returned if it aborts the collection of the pool, all other errors are recorded so that the remaining
+// collectors still run.
+func (c *zfsCollector) runCollector(ch *chan<- prometheus.Metric, collector string, pool string, collect func() error) error {
+	start := time.Now()
+	err := collect()
+	if errors.Is(err, ioctl.ErrPoolNotFound) {
+		return err
+	}
+	if exportErr := c.collectorDone(ch, collector, pool, time.Since(start), err); exportErr != nil {
+		return exportErr
+	}
+	if abortsPool(err) {
+		return err
+	}
+	return nil
+}
@@ -1,27 +1,44 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
+	"io/fs"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
-	"path"
-	"strings"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
-	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/kstat"
-	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/nvlist"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"golang.org/x/sys/unix"
 )
 
 var (
-	listenAddr = flag.String("listen-addr", "127.0.0.1:9901", "Address and port to listen on")
+	listenAddr     = flag.String("listen-addr", "127.0.0.1:9901", "Address and port to listen on")
+	zpoolCachePath = flag.String("zpool-cache-path", "/etc/zfs/zpool.cache", "Path to the zpool cache file used to detect pools that are cached but not imported")
+	recordDir      = flag.String("record-dir", "", "Record all ioctls and kstats to this directory, so that they can be replayed with -replay-dir")
+	replayDir      = flag.String("replay-dir", "", "Replay ioctls and kstats recorded with -record-dir from this directory instead of using ZFS")
+
+	runAsUser        = flag.String("user", "", "Switch to this user after opening /dev/zfs, dropping all capabilities")
+	runAsGroup       = flag.String("group", "", "Switch to this group after opening /dev/zfs, defaults to the primary group of -user")
+	zfsFD            = flag.Int("zfs-fd", -1, "Use this already opened file descriptor of /dev/zfs, e.g. passed with systemd's OpenFile=, instead of opening it")
+	createDeviceNode = flag.Bool("create-device-node", false, "Create /dev/zfs if it does not exist")
+	collectInterval  = flag.Duration("collect-interval", 0, "Collect in the background at this interval and serve the latest collection, instead of collecting on every scrape")
+	poolTimeout      = flag.Duration("pool-timeout", 10*time.Second, "Give up collecting a pool if one of its ioctls does not return within this time, e.g. because the pool is suspended")
+	nativeHistograms = flag.Bool("native-histograms", false, "Export the vdev latency histograms as native histograms instead of classic histograms")
+)
+
+const (
+	defaultKStatPath = "/proc/spl/kstat/zfs"
+	zfsDevicePath    = "/dev/zfs"
 )
 
 func describe(ch *chan<- *prometheus.Desc, desc **prometheus.Desc, d *prometheus.Desc) {
@@ -43,740 +60,377 @@ func export(ch *chan<- prometheus.Metric, desc *prometheus.Desc, valueType prome
 }
 
 type zfsCollector struct {
-	zfsHandle *ioctl.ZFSHandle
-
-	poolState      *prometheus.Desc
-	poolErrorCount *prometheus.Desc
-
-	poolVdevState          *prometheus.Desc
-	poolVdevAllocSpaceDesc *prometheus.Desc
-	poolVdevTotalSpaceDesc *prometheus.Desc
-	poolVdevDefSpaceDesc   *prometheus.Desc
-	poolVdevRepDevSizeDesc *prometheus.Desc
-	poolVdevPhysSpace      *prometheus.Desc
-
-	poolVdevReadOps    *prometheus.Desc
-	poolVdevReadBytes  *prometheus.Desc
-	poolVdevReadErrors *prometheus.Desc
-
-	poolVdevWriteOps    *prometheus.Desc
-	poolVdevWriteBytes  *prometheus.Desc
-	poolVdevWriteErrors *prometheus.Desc
-
-	poolVdevChechsumErrors *prometheus.Desc
-	poolVdevSlowIos        *prometheus.Desc
-
-	datasetAvailable            *prometheus.Desc
-	datasetCompressRatio        *prometheus.Desc
-	datasetUsed                 *prometheus.Desc
-	datasetUsedByChildren       *prometheus.Desc
-	datasetUsedByDataset        *prometheus.Desc
-	datasetUsedByRefReservation *prometheus.Desc
-	datasetUsedBySnapshots      *prometheus.Desc
-	datasetReferenced           *prometheus.Desc
-	datasetRefCompressRatio     *prometheus.Desc
-	datasetLogicalReferenced    *prometheus.Desc
-	datasetLogicalUsed          *prometheus.Desc
-
-	datasetWrites    *prometheus.Desc
-	datasetNWritten  *prometheus.Desc
-	datasetReads     *prometheus.Desc
-	datasetNRead     *prometheus.Desc
-	datasetUnlinks   *prometheus.Desc
-	datasetNUnlinked *prometheus.Desc
+	zfs *ioctl.Client
+	// kstatPath is the directory holding the kstats of all pools. If kstatRecordDir is set, all kstats read are
+	// copied to it.
+	kstatPath      string
+	kstatRecordDir string
+	// zpoolCachePath is the zpool cache file, the pools in it are reported with whether they are imported. If
+	// it is empty, no cache file is read.
+	zpoolCachePath string
+	// nativeHistograms exports histograms as native histograms instead of classic histograms.
+	nativeHistograms bool
+	// collectorNames are the names of the collectors run. If nil, the collectors enabled by the flags are run.
+	collectorNames   []string
+	collectors       []namedCollector
+	systemCollectors []namedSystemCollector
+
+	// stalled holds the pools whose collection was given up, and the empty name if listing the pools was.
+	// Their ioctl still blocks a goroutine until the channel is closed, they are skipped until then.
+	stalledMu sync.Mutex
+	stalled   map[string]<-chan struct{}
+
+	// collection is the collection in progress, shared by all scrapes arriving while it runs.
+	collectionMu sync.Mutex
+	collection   *collection
+	// latest is the snapshot collected in the background. If it is set, it is served instead of collecting on
+	// every scrape.
+	latest atomic.Pointer[snapshot]
+
+	up             *prometheus.Desc
+	poolStalled    *prometheus.Desc
+	lastCollection *prometheus.Desc
+
+	collectorSuccess  *prometheus.Desc
+	collectorDuration *prometheus.Desc
+	// errorsTotal counts the errors of all scrapes, it is created by the first describe.
+	errorsTotal *prometheus.CounterVec
 }
 
 func (c *zfsCollector) describe(ch *chan<- *prometheus.Desc) {
-	describe(ch, &c.poolState, prometheus.NewDesc("zfs_pool_state", "", []string{"pool", "state"}, nil))
-	describe(ch, &c.poolErrorCount, prometheus.NewDesc("zfs_pool_error_count", "", []string{"pool"}, nil))
-
-	describe(ch, &c.poolVdevState, prometheus.NewDesc("zfs_pool_vdev_state", "", []string{"pool", "vdev", "vdev_type", "state"}, nil))
-	describe(ch, &c.poolVdevAllocSpaceDesc, prometheus.NewDesc("zfs_pool_vdev_alloc_space", "", []string{"pool", "vdev", "vdev_type"}, nil))
-	describe(ch, &c.poolVdevTotalSpaceDesc, prometheus.NewDesc("zfs_pool_vdev_total_space", "", []string{"pool", "vdev", "vdev_type"}, nil))
-	describe(ch, &c.poolVdevDefSpaceDesc, prometheus.NewDesc("zfs_pool_vdev_def_space", "", []string{"pool", "vdev", "vdev_type"}, nil))
-	describe(ch, &c.poolVdevRepDevSizeDesc, prometheus.NewDesc("zfs_pool_vdev_rep_dev_size", "", []string{"pool", "vdev", "vdev_type"}, nil))
-	describe(ch, &c.poolVdevPhysSpace, prometheus.NewDesc("zfs_pool_vdev_phys_space", "", []string{"pool", "vdev", "vdev_type"}, nil))
-
-	describe(ch, &c.poolVdevReadOps, prometheus.NewDesc("zfs_pool_vdev_read_ops", "", []string{"pool", "vdev", "vdev_type"}, nil))
-	describe(ch, &c.poolVdevReadBytes, prometheus.NewDesc("zfs_pool_vdev_read_bytes", "", []string{"pool", "vdev", "vdev_type"}, nil))
-	describe(ch, &c.poolVdevReadErrors, prometheus.NewDesc("zfs_pool_vdev_read_errors", "", []string{"pool", "vdev", "vdev_type"}, nil))
-
-	describe(ch, &c.poolVdevWriteOps, prometheus.NewDesc("zfs_pool_vdev_write_ops", "", []string{"pool", "vdev", "vdev_type"}, nil))
-	describe(ch, &c.poolVdevWriteBytes, prometheus.NewDesc("zfs_pool_vdev_write_bytes", "", []string{"pool", "vdev", "vdev_type"}, nil))
-	describe(ch, &c.poolVdevWriteErrors, prometheus.NewDesc("zfs_pool_vdev_write_errors", "", []string{"pool", "vdev", "vdev_type"}, nil))
-
-	describe(ch, &c.poolVdevChechsumErrors, prometheus.NewDesc("zfs_pool_vdev_checksum_errors", "", []string{"pool", "vdev", "vdev_type"}, nil))
-	describe(ch, &c.poolVdevSlowIos, prometheus.NewDesc("zfs_pool_vdev_slow_ios", "", []string{"pool", "vdev", "vdev_type"}, nil))
-
-	describe(ch, &c.datasetAvailable, prometheus.NewDesc("zfs_dataset_available", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetCompressRatio, prometheus.NewDesc("zfs_dataset_compress_ratio", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetUsed, prometheus.NewDesc("zfs_dataset_used", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetUsedByChildren, prometheus.NewDesc("zfs_dataset_used_by_children", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetUsedByDataset, prometheus.NewDesc("zfs_dataset_used_by_dataset", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetUsedByRefReservation, prometheus.NewDesc("zfs_dataset_used_by_ref_reservation", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetUsedBySnapshots, prometheus.NewDesc("zfs_dataset_used_by_snapshots", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetReferenced, prometheus.NewDesc("zfs_dataset_referenced", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetRefCompressRatio, prometheus.NewDesc("zfs_dataset_ref_compress_ratio", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetLogicalReferenced, prometheus.NewDesc("zfs_dataset_logical_referenced", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetLogicalUsed, prometheus.NewDesc("zfs_dataset_logical_used", "", []string{"name", "pool"}, nil))
-
-	describe(ch, &c.datasetWrites, prometheus.NewDesc("zfs_dataset_writes", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetNWritten, prometheus.NewDesc("zfs_dataset_nwritten", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetReads, prometheus.NewDesc("zfs_dataset_reads", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetNRead, prometheus.NewDesc("zfs_dataset_nread", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetUnlinks, prometheus.NewDesc("zfs_dataset_nunlinks", "", []string{"name", "pool"}, nil))
-	describe(ch, &c.datasetNUnlinked, prometheus.NewDesc("zfs_dataset_nunlinked", "", []string{"name", "pool"}, nil))
+	describe(ch, &c.up, prometheus.NewDesc("zfs_up", "Whether the ZFS kernel module could be reached", nil, nil))
+	describe(ch, &c.lastCollection, prometheus.NewDesc("zfs_exporter_last_collection_timestamp_seconds", "Time the served metrics were collected as unix timestamp", nil, nil))
+	describe(ch, &c.poolStalled, prometheus.NewDesc("zfs_pool_collection_stalled", "Whether collecting the pool was given up because its ioctls did not return in time", []string{"pool"}, nil))
+
+	describe(ch, &c.collectorSuccess, prometheus.NewDesc("zfs_exporter_collector_success", "Whether the collector succeeded", []string{"collector", "pool"}, nil))
+	describe(ch, &c.collectorDuration, prometheus.NewDesc("zfs_exporter_collector_duration_seconds", "Time the collector took", []string{"collector", "pool"}, nil))
+	if c.errorsTotal == nil {
+		c.errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zfs_exporter_errors_total",
+			Help: "Errors of the collectors by reason",
+		}, []string{"collector", "reason"})
+	}
+	if ch != nil {
+		c.errorsTotal.Describe(*ch)
+	}
+
+	if c.collectors == nil && c.systemCollectors == nil {
+		names := c.collectorNames
+		if names == nil {
+			names = enabledCollectorNames()
+		}
+		c.collectors, c.systemCollectors = newCollectors(names, collectorConfig{
+			nativeHistograms: c.nativeHistograms,
+			readKStat:        c.readKStat,
+			zpoolCachePath:   c.zpoolCachePath,
+		})
+	}
+	for _, collector := range c.collectors {
+		collector.Describe(ch)
+	}
+	for _, collector := range c.systemCollectors {
+		collector.Describe(ch)
+	}
 }
 
 func (c *zfsCollector) Describe(ch chan<- *prometheus.Desc) {
 	c.describe(&ch)
 }
 
-func (c *zfsCollector) handleVdev(ch *chan<- prometheus.Metric, pool string, vdevNamePrefix string, vdev *vdev) error {
-	vdevName := ""
-	if vdev.path != "" {
-		p := path.Base(vdev.path)
-		vdevName = p
-	} else {
-		if vdev.vdevType == "root" {
-			vdevName = pool
-		} else {
-			vdevName = fmt.Sprintf("%s-%d", vdev.vdevType, vdev.id)
-		}
-	}
-	vdevName = vdevNamePrefix + vdevName
-
-	labels := []string{pool, vdevName, vdev.vdevType}
-
-	for _, vdevState := range ioctl.VDevStates {
-		val := 0.0
-		if vdevState == vdev.vdevStats.state {
-			val = 1.0
-		}
-		metric, err := prometheus.NewConstMetric(c.poolVdevState, prometheus.GaugeValue, val, pool, vdevName, vdev.vdevType, vdevState)
+// handlePool runs the enabled collectors for a pool. Each of them fails on its own, only a vanished or stalled
+// pool ends the collection of the pool early.
+func (c *zfsCollector) handlePool(ctx context.Context, ch *chan<- prometheus.Metric, poolName string) error {
+	pool := newPoolScrape(ctx, c.zfs, poolName)
+	for _, collector := range c.collectors {
+		err := c.runCollector(ch, collector.name, poolName, func() error {
+			return collector.Update(ch, pool)
+		})
 		if err != nil {
 			return err
 		}
-		if ch != nil {
-			*ch <- metric
-		}
-	}
-
-	if err := export(ch, c.poolVdevAllocSpaceDesc, prometheus.GaugeValue, float64(vdev.vdevStats.alloc), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.poolVdevTotalSpaceDesc, prometheus.GaugeValue, float64(vdev.vdevStats.size), labels); err != nil {
-		return err
-	}
-	// if err := export(ch, c.poolVdevDefSpaceDesc, prometheus.GaugeValue, vdev.DefSpace, labels); err != nil {
-	// 	return err
-	// }
-	// if err := export(ch, c.poolVdevRepDevSizeDesc, prometheus.GaugeValue, vdev.RepDevSize, labels); err != nil {
-	// 	return err
-	// }
-	// if err := export(ch, c.poolVdevPhysSpace, prometheus.GaugeValue, vdev.PhysSpace, labels); err != nil {
-	// 	return err
-	// }
-
-	if err := export(ch, c.poolVdevReadOps, prometheus.CounterValue, float64(vdev.vdevStats.readOperations), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.poolVdevReadBytes, prometheus.CounterValue, float64(vdev.vdevStats.bytesRead), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.poolVdevReadErrors, prometheus.CounterValue, float64(vdev.vdevStats.readErrors), labels); err != nil {
-		return err
 	}
+	return nil
+}
 
-	if err := export(ch, c.poolVdevWriteOps, prometheus.CounterValue, float64(vdev.vdevStats.writeOperations), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.poolVdevWriteBytes, prometheus.CounterValue, float64(vdev.vdevStats.bytesWritten), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.poolVdevWriteErrors, prometheus.CounterValue, float64(vdev.vdevStats.writeErrors), labels); err != nil {
-		return err
+// stillStalled returns whether an ioctl of a pool whose collection was given up is still blocked.
+func (c *zfsCollector) stillStalled(pool string) bool {
+	c.stalledMu.Lock()
+	defer c.stalledMu.Unlock()
+	done, ok := c.stalled[pool]
+	if !ok {
+		return false
+	}
+	select {
+	case <-done:
+		delete(c.stalled, pool)
+		return false
+	default:
+		return true
 	}
+}
 
-	if err := export(ch, c.poolVdevChechsumErrors, prometheus.CounterValue, float64(vdev.vdevStats.checksumErrors), labels); err != nil {
-		return err
+// setStalled records that the collection of pool was given up until done is closed.
+func (c *zfsCollector) setStalled(pool string, done <-chan struct{}) {
+	c.stalledMu.Lock()
+	defer c.stalledMu.Unlock()
+	if c.stalled == nil {
+		c.stalled = make(map[string]<-chan struct{})
 	}
-	// if err := export(ch, c.poolVdevSlowIos, prometheus.CounterValue, vdev.SlowIos, labels); err != nil {
-	// 	return err
-	// }
+	c.stalled[pool] = done
+}
 
-	for _, child := range vdev.children {
-		err := c.handleVdev(ch, pool, vdevName+"/", child)
-		if err != nil {
+// collectPool collects a pool unless its last collection stalled, and exports whether it stalled. The
+// collection is given up if one of its ioctls does not return within the timeout of the client.
+func (c *zfsCollector) collectPool(ctx context.Context, ch *chan<- prometheus.Metric, pool string) error {
+	stalled := c.stillStalled(pool)
+	if !stalled {
+		err := c.handlePool(ctx, ch, pool)
+		var stalledErr *ioctl.StalledError
+		if errors.As(err, &stalledErr) {
+			slog.Warn("giving up collecting pool", "pool", pool, "error", err)
+			c.setStalled(pool, stalledErr.Done)
+			stalled = true
+		} else if err != nil {
 			return err
 		}
 	}
 
-	return nil
-}
-
-func (c *zfsCollector) handleDataset(ch *chan<- prometheus.Metric, pool string, name string, props *datasetProps) error {
-	labels := []string{name, pool}
-
-	if err := export(ch, c.datasetAvailable, prometheus.GaugeValue, float64(props.available), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetCompressRatio, prometheus.GaugeValue, float64(props.compressratio)/100, labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetUsed, prometheus.GaugeValue, float64(props.used), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetUsedByChildren, prometheus.GaugeValue, float64(props.usedbychildren), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetUsedByDataset, prometheus.GaugeValue, float64(props.usedbydataset), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetUsedByRefReservation, prometheus.GaugeValue, float64(props.usedbyrefreservation), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetUsedBySnapshots, prometheus.GaugeValue, float64(props.usedbysnapshots), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetReferenced, prometheus.GaugeValue, float64(props.referenced), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetRefCompressRatio, prometheus.GaugeValue, float64(props.refcompressratio)/100, labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetLogicalReferenced, prometheus.GaugeValue, float64(props.logicalreferenced), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetLogicalUsed, prometheus.GaugeValue, float64(props.logicalused), labels); err != nil {
-		return err
+	val := 0.0
+	if stalled {
+		val = 1.0
 	}
+	return export(ch, c.poolStalled, prometheus.GaugeValue, val, []string{pool})
+}
 
-	if err := export(ch, c.datasetWrites, prometheus.CounterValue, float64(props.writes), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetNWritten, prometheus.CounterValue, float64(props.nwritten), labels); err != nil {
-		return err
-	}
+// poolConfigsStalled is the key of the stalled ZFS_IOC_POOL_CONFIGS in zfsCollector.stalled, no pool has an
+// empty name.
+const poolConfigsStalled = ""
 
-	if err := export(ch, c.datasetReads, prometheus.CounterValue, float64(props.reads), labels); err != nil {
-		return err
-	}
-	if err := export(ch, c.datasetNRead, prometheus.CounterValue, float64(props.nread), labels); err != nil {
-		return err
+func (c *zfsCollector) collect(ch *chan<- prometheus.Metric) error {
+	start := time.Now()
+	configs, err := c.poolConfigs()
+	if errors.Is(err, ioctl.ErrUnavailable) {
+		slog.Warn("zfs is not available", "error", err)
+		return export(ch, c.up, prometheus.GaugeValue, 0, nil)
 	}
-
-	if err := export(ch, c.datasetUnlinks, prometheus.CounterValue, float64(props.nunlinks), labels); err != nil {
+	if err := export(ch, c.up, prometheus.GaugeValue, 1, nil); err != nil {
 		return err
 	}
-	if err := export(ch, c.datasetNUnlinked, prometheus.CounterValue, float64(props.nunlinked), labels); err != nil {
-		return err
+	if exportErr := c.collectorDone(ch, "pool-configs", "", time.Since(start), err); exportErr != nil || err != nil {
+		// Without the imported pools, all pools of the zpool cache would be reported as not imported.
+		return exportErr
 	}
 
-	return nil
-}
-
-type datasetProps struct {
-	objsetid uint64
-
-	available            uint64
-	compressratio        uint64
-	used                 uint64
-	usedbychildren       uint64
-	usedbydataset        uint64
-	usedbyrefreservation uint64
-	usedbysnapshots      uint64
-	referenced           uint64
-	refcompressratio     uint64
-	logicalreferenced    uint64
-	logicalused          uint64
-
-	hasKStats bool
-	writes    uint64
-	nwritten  uint64
-	reads     uint64
-	nread     uint64
-	nunlinks  uint64
-	nunlinked uint64
-}
-
-func (d *datasetProps) parseValue(r *nvlist.NVListReader, propName string) error {
-	for {
-		token, err := r.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
+	for _, config := range configs {
+		err = c.collectPool(context.Background(), ch, config.Name)
+		if errors.Is(err, ioctl.ErrPoolNotFound) {
+			// The pool was exported or destroyed after listing the pools.
+			slog.Debug("pool vanished during collection", "pool", config.Name, "error", err)
+			continue
 		}
-
-		if r.Name() == "value" {
-			switch propName {
-			case "objsetid":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for objsetid")
-				}
-				d.objsetid = r.UInt64()
-			case "available":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for available")
-				}
-				d.available = r.UInt64()
-			case "compressratio":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for compressratio")
-				}
-				d.compressratio = r.UInt64()
-			case "used":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for used")
-				}
-				d.used = r.UInt64()
-			case "usedbychildren":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for usedbychildren")
-				}
-				d.usedbychildren = r.UInt64()
-			case "usedbydataset":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for usedbydataset")
-				}
-				d.usedbydataset = r.UInt64()
-			case "usedbyrefreservation":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for usedbyrefreservation")
-				}
-				d.usedbyrefreservation = r.UInt64()
-			case "usedbysnapshots":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for usedbysnapshots")
-				}
-				d.usedbysnapshots = r.UInt64()
-			case "referenced":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for referenced")
-				}
-				d.referenced = r.UInt64()
-			case "refcompressratio":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for refcompressratio")
-				}
-				d.refcompressratio = r.UInt64()
-			case "logicalreferenced":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for logicalreferenced")
-				}
-				d.logicalreferenced = r.UInt64()
-			case "logicalused":
-				if token != nvlist.TypeUint64 {
-					return fmt.Errorf("invalid type for logicalused")
-				}
-				d.logicalused = r.UInt64()
-			}
-		}
-	}
-	return nil
-}
-
-func (d *datasetProps) parseProps(r *nvlist.NVListReader) error {
-	for {
-		token, err := r.Next()
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
 			return err
 		}
-
-		if token == nvlist.TypeNvlist {
-			err = d.parseValue(r, r.Name())
-			if err != nil {
-				return err
-			}
-		} else {
-			return fmt.Errorf("expected nvlist, got %v", token)
-		}
 	}
 
-	return nil
-}
-
-func (d *datasetProps) parseKStat(r *kstat.KStatReader) error {
-	for {
-		name, err := r.Next()
+	for _, collector := range c.systemCollectors {
+		err := c.runCollector(ch, collector.name, "", func() error {
+			return collector.Update(ch, configs)
+		})
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
 			return err
 		}
-
-		switch name {
-		case "writes":
-			d.writes, err = r.RowDataAsUInt64()
-			if err != nil {
-				return fmt.Errorf("error reading \"writes\" row: %w", err)
-			}
-		case "nwritten":
-			d.nwritten, err = r.RowDataAsUInt64()
-			if err != nil {
-				return fmt.Errorf("error reading \"nwritten\" row: %w", err)
-			}
-		case "reads":
-			d.reads, err = r.RowDataAsUInt64()
-			if err != nil {
-				return fmt.Errorf("error reading \"reads\" row: %w", err)
-			}
-		case "nread":
-			d.nread, err = r.RowDataAsUInt64()
-			if err != nil {
-				return fmt.Errorf("error reading \"nread\" row: %w", err)
-			}
-		case "nunlinks":
-			d.nunlinks, err = r.RowDataAsUInt64()
-			if err != nil {
-				return fmt.Errorf("error reading \"nunlinks\" row: %w", err)
-			}
-		case "nunlinked":
-			d.nunlinked, err = r.RowDataAsUInt64()
-			if err != nil {
-				return fmt.Errorf("error reading \"nunlinked\" row: %w", err)
-			}
-		}
 	}
-
 	return nil
 }
 
-type vdevStats struct {
-	alloc           uint64
-	free            uint64
-	size            uint64
-	readOperations  uint64
-	bytesRead       uint64
-	readErrors      uint64
-	writeOperations uint64
-	bytesWritten    uint64
-	writeErrors     uint64
-	checksumErrors  uint64
-	fragmentation   uint64
-	state           string
-}
-
-func parseVdevStats(vdevStats []uint64) (s vdevStats) {
-	s.alloc = vdevStats[ioctl.VDevStats_vs_alloc]
-	s.free = vdevStats[ioctl.VDevStats_vs_space] - vdevStats[ioctl.VDevStats_vs_alloc]
-	s.size = vdevStats[ioctl.VDevStats_vs_space]
-
-	s.readOperations = vdevStats[ioctl.VDevStats_vs_ops_read]
-	s.bytesRead = vdevStats[ioctl.VDevStats_vs_bytes_read]
-	s.readErrors = vdevStats[ioctl.VDevStats_vs_read_errors]
-
-	s.writeOperations = vdevStats[ioctl.VDevStats_vs_ops_write]
-	s.bytesWritten = vdevStats[ioctl.VDevStats_vs_bytes_write]
-	s.writeErrors = vdevStats[ioctl.VDevStats_vs_write_errors]
-
-	s.checksumErrors = vdevStats[ioctl.VDevStats_vs_checksum_errors]
-	s.fragmentation = vdevStats[ioctl.VDevStats_vs_fragmentation]
-
-	s.state = ioctl.VDevStateString(vdevStats[ioctl.VDevStats_vs_state], vdevStats[ioctl.VDevStats_vs_aux])
-
-	return
+// poolConfigs lists the imported pools, unless the last listing stalled and is still blocked.
+func (c *zfsCollector) poolConfigs() ([]ioctl.PoolConfig, error) {
+	if c.stillStalled(poolConfigsStalled) {
+		return nil, fmt.Errorf("%v is still stalled: %w", ioctl.ZFS_IOC_POOL_CONFIGS, context.DeadlineExceeded)
+	}
+	configs, err := c.zfs.PoolConfigs(context.Background())
+	var stalledErr *ioctl.StalledError
+	if errors.As(err, &stalledErr) {
+		c.setStalled(poolConfigsStalled, stalledErr.Done)
+	}
+	return configs, err
 }
 
-func parseVdevs(vdevReader *nvlist.NVListReader) (*vdev, error) {
-	vdev := &vdev{}
-
-	for {
-		token, err := vdevReader.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
-
-		// Parse the vdev class to be able to add it as a label upon export:
-		// https://sourcegraph.com/github.com/openzfs/zfs@3862ebbf1fe1f8755f9956a8eaecaefc428c8f31/-/blob/cmd/zpool/zpool_main.c?L1208-1247
-
-		if vdevReader.Name() == "children" {
-			if token != nvlist.TypeNvlistArray {
-				return nil, fmt.Errorf("invalid children")
-			}
-			numChildren := vdevReader.NumElements()
-			for i := 0; i < numChildren; i++ {
-				child, err := parseVdevs(vdevReader)
-				if err != nil {
-					return nil, err
-				}
-				vdev.children = append(vdev.children, child)
-			}
-		} else if vdevReader.Name() == "type" {
-			if token != nvlist.TypeString {
-				return nil, fmt.Errorf("invalid type")
-			}
-			s, err := vdevReader.String()
-			if err != nil {
-				return nil, err
-			}
-
-			vdev.vdevType = strings.Clone(s)
-		} else if vdevReader.Name() == "id" {
-			if token != nvlist.TypeUint64 {
-				return nil, fmt.Errorf("invalid type")
-			}
-			vdev.id = vdevReader.UInt64()
-		} else if vdevReader.Name() == "path" {
-			if token != nvlist.TypeString {
-				return nil, fmt.Errorf("invalid type")
-			}
-			s, err := vdevReader.String()
-			if err != nil {
-				return nil, err
-			}
-			vdev.path = strings.Clone(s)
-		} else if vdevReader.Name() == "vdev_stats" {
-			if token != nvlist.TypeUint64Array {
-				return nil, fmt.Errorf("invalid vdev_stats")
-			}
-			vdev.vdevStats = parseVdevStats(vdevReader.UInt64Array())
-		} else if token == nvlist.TypeNvlist || token == nvlist.TypeNvlistArray {
-			err = vdevReader.Skip()
-			if err != nil {
-				return nil, err
-			}
-		}
+func (c *zfsCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.latest.Load()
+	if s == nil {
+		s = c.collectSnapshot()
 	}
-
-	return vdev, nil
+	for _, metric := range s.metrics {
+		ch <- metric
+	}
+	timestamp := float64(s.timestamp.UnixNano()) / float64(time.Second)
+	if err := export(&ch, c.lastCollection, prometheus.GaugeValue, timestamp, nil); err != nil {
+		slog.Error("error exporting last collection timestamp", "error", err)
+	}
+	c.errorsTotal.Collect(ch)
 }
 
-type vdev struct {
-	vdevType string
-	id       uint64
-	path     string
-	children []*vdev
-
-	vdevStats vdevStats
+// readKStat reads the kstat name of a pool.
+func (c *zfsCollector) readKStat(pool string, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(c.kstatPath, pool, name))
+	if err != nil || c.kstatRecordDir == "" {
+		return data, err
+	}
+	if err := os.MkdirAll(filepath.Join(c.kstatRecordDir, pool), 0o755); err != nil {
+		return nil, err
+	}
+	return data, os.WriteFile(filepath.Join(c.kstatRecordDir, pool, name), data, 0o644)
 }
 
-func (c *zfsCollector) handlePool(ch *chan<- prometheus.Metric, poolName string) error {
-	cmd := ioctl.Cmd{}
-	cmd.SetName(poolName)
-	resp := make([]byte, 256*1024)
-	err := c.zfsHandle.Ioctl(ioctl.ZFS_IOC_POOL_STATS, &cmd, nil, nil, &resp)
+// openZFSHandle opens /dev/zfs, or uses the file descriptor fd if it is not negative.
+func openZFSHandle(fd int) (*ioctl.ZFSHandle, error) {
+	abi, err := ioctl.DetectABI()
 	if err != nil {
-		return err
-	}
-
-	var state string
-	var errorCount uint64
-	var vdev *vdev
-
-	poolStatsReader := nvlist.NVListReader{Data: resp}
-
-	for {
-		token, err := poolStatsReader.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return err
-		}
-		if poolStatsReader.Name() == "state" {
-			if token != nvlist.TypeUint64 {
-				return fmt.Errorf("invalid state")
-			}
-			state = ioctl.PoolStateString(poolStatsReader.UInt64())
-		} else if poolStatsReader.Name() == "error_count" {
-			if token != nvlist.TypeUint64 {
-				return fmt.Errorf("invalid error_count")
-			}
-			errorCount = poolStatsReader.UInt64()
-		} else if poolStatsReader.Name() == "error_count" {
-			if token != nvlist.TypeUint64 {
-				return fmt.Errorf("invalid error_count")
-			}
-			errorCount = poolStatsReader.UInt64()
-		} else if poolStatsReader.Name() == "vdev_tree" {
-			if token != nvlist.TypeNvlist {
-				return fmt.Errorf("invalid vdev_tree")
-			}
-			vdev, err = parseVdevs(&poolStatsReader)
-			if err != nil {
-				return err
-			}
-		} else if token == nvlist.TypeNvlist || token == nvlist.TypeNvlistArray {
-			err = poolStatsReader.Skip()
-			if err != nil {
-				return err
-			}
-		}
+		return nil, err
 	}
 
-	for _, poolState := range ioctl.PoolStates {
-		val := 0.0
-		if poolState == state {
-			val = 1.0
+	if fd >= 0 {
+		f := os.NewFile(uintptr(fd), zfsDevicePath)
+		if f == nil {
+			return nil, fmt.Errorf("invalid file descriptor %d", fd)
 		}
-		metric, err := prometheus.NewConstMetric(c.poolState, prometheus.GaugeValue, val, poolName, poolState)
+		info, err := f.Stat()
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("error checking file descriptor %d: %w", fd, err)
 		}
-		if ch != nil {
-			*ch <- metric
+		if info.Mode()&os.ModeCharDevice == 0 {
+			return nil, fmt.Errorf("file descriptor %d is not a device node", fd)
 		}
+		return ioctl.NewZFSHandleWithFile(f, abi), nil
 	}
 
-	metric, err := prometheus.NewConstMetric(c.poolErrorCount, prometheus.CounterValue, float64(errorCount), poolName)
-	if err != nil {
-		return err
-	}
-	if ch != nil {
-		*ch <- metric
-	}
-
-	err = c.handleVdev(ch, poolName, "", vdev)
-	if err != nil {
-		return err
-	}
-
-	var findDatasetsRecursive func(prefix string) error
-	findDatasetsRecursive = func(prefix string) error {
-		cookie := uint64(0)
-		for {
-			cmd.Clear()
-			cmd.SetName(prefix)
-			cmd.Cookie = cookie
-			err = c.zfsHandle.Ioctl(ioctl.ZFS_IOC_DATASET_LIST_NEXT, &cmd, nil, nil, &resp)
-			if err == unix.ESRCH {
-				return nil
-			}
-			if err != nil {
-				return fmt.Errorf("error calling dataset list next: %w", err)
-			}
-			name := cmd.GetName()
-			cookie = cmd.Cookie
-
-			datasetPropsReader := nvlist.NVListReader{Data: resp}
-			props := datasetProps{}
-			err = props.parseProps(&datasetPropsReader)
-			if err != nil {
-				return err
-			}
-
-			kstatData, err := os.ReadFile(fmt.Sprintf("/proc/spl/kstat/zfs/%s/objset-0x%x", poolName, props.objsetid))
-			if err != nil {
-				// Either kstats not supported or dataset not mounted...
-				if !os.IsNotExist(err) {
-					return fmt.Errorf("error reading kstats for %q (objset %v): %w", name, props.objsetid, err)
-				}
-			} else {
-				r := kstat.KStatReader{
-					Data: kstatData,
-				}
-				err := props.parseKStat(&r)
-				if err != nil {
-					return fmt.Errorf("error parsing kstats for %q (objset %v): %w", name, props.objsetid, err)
-				}
-			}
-
-			err = c.handleDataset(ch, poolName, name, &props)
-			if err != nil {
-				return err
-			}
-
-			err = findDatasetsRecursive(name)
-			if err != nil {
-				return err
+	if *createDeviceNode {
+		if _, err := os.Stat(zfsDevicePath); os.IsNotExist(err) {
+			if err := ioctl.CreateDeviceNode(zfsDevicePath); err != nil {
+				return nil, err
 			}
 		}
 	}
-	err = findDatasetsRecursive(poolName)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return ioctl.NewZFSHandleWithABI(zfsDevicePath, abi)
 }
 
-func (c *zfsCollector) collect(ch *chan<- prometheus.Metric) error {
-	cmd := ioctl.Cmd{}
-	resp := make([]byte, 256*1024)
-	err := c.zfsHandle.Ioctl(ioctl.ZFS_IOC_POOL_CONFIGS, &cmd, nil, nil, &resp)
-	if err != nil {
-		return err
-	}
-
-	poolConfigsReader := nvlist.NVListReader{Data: resp}
-	for {
-		token, err := poolConfigsReader.Next()
-		if err != nil {
-			if err == io.EOF {
-				break
+// newHandle opens /dev/zfs, reopening it if needed, or the recorded ioctls if -replay-dir is set. With -record-dir all ioctls are
+// recorded. Only read-only ioctls are passed on, so a bug in the exporter cannot modify pools or datasets.
+func newHandle() (ioctl.Handle, error) {
+	var zfsHandle ioctl.Handle
+	if *replayDir != "" {
+		zfsHandle = &ioctl.ReplayHandle{Dir: *replayDir}
+	} else {
+		fd := *zfsFD
+		h := &ioctl.ReopeningHandle{Open: func() (ioctl.Handle, error) {
+			zfsHandle, err := openZFSHandle(fd)
+			if err != nil {
+				return nil, err
 			}
-			return err
-		}
-		if token != nvlist.TypeNvlist {
-			return fmt.Errorf("invalid pool configs")
-		}
-
-		poolName := strings.Clone(poolConfigsReader.Name())
-		err = c.handlePool(ch, poolName)
-		if err != nil {
-			return err
+			// The passed descriptor refers to the device of the module loaded at startup, reopen /dev/zfs.
+			fd = -1
+			return zfsHandle, nil
+		}}
+		// Open /dev/zfs right away, before privileges are dropped. If the kernel module is not loaded yet, it
+		// is opened by the first scrape after it was.
+		if err := h.Available(); errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("zfs is not available yet, retrying on every scrape", "error", err)
+		} else if err != nil {
+			return nil, fmt.Errorf("error creating zfs handle: %w", err)
 		}
+		zfsHandle = h
+	}
+	zfsHandle = &ioctl.ReadOnlyHandle{Handle: zfsHandle}
 
-		err = poolConfigsReader.Skip()
-		if err != nil {
-			return err
+	if *recordDir != "" {
+		if err := os.MkdirAll(*recordDir, 0o755); err != nil {
+			return nil, err
 		}
+		zfsHandle = &ioctl.Recorder{Handle: zfsHandle, Dir: *recordDir}
 	}
-	return nil
+	return zfsHandle, nil
 }
 
-func (c *zfsCollector) Collect(ch chan<- prometheus.Metric) {
-	err := c.collect(&ch)
+func newZFSCollector() (*zfsCollector, error) {
+	zfsHandle, err := newHandle()
 	if err != nil {
-		slog.Error("error collecting and exporting zfs metrics", "error", err)
+		return nil, err
 	}
+
+	c := &zfsCollector{
+		zfs:              &ioctl.Client{Handle: zfsHandle, Timeout: *poolTimeout},
+		kstatPath:        defaultKStatPath,
+		zpoolCachePath:   *zpoolCachePath,
+		nativeHistograms: *nativeHistograms,
+	}
+	if *replayDir != "" {
+		c.kstatPath = filepath.Join(*replayDir, "kstat")
+	}
+	if *recordDir != "" {
+		c.kstatRecordDir = filepath.Join(*recordDir, "kstat")
+	}
+	return c, nil
 }
 
-func setup(reg *prometheus.Registry) error {
-	zfsHandle, err := ioctl.NewZFSHandle()
+func setup(reg *prometheus.Registry) (*zfsCollector, error) {
+	c, err := newZFSCollector()
 	if err != nil {
-		return fmt.Errorf("error creating zfs handle: %w", err)
+		return nil, err
 	}
 
-	err = reg.Register(&zfsCollector{zfsHandle: zfsHandle})
+	err = reg.Register(c)
 	if err != nil {
-		return fmt.Errorf("error registering zfs collector: %w", err)
+		return nil, fmt.Errorf("error registering zfs collector: %w", err)
 	}
 
 	err = reg.Register(
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
 	if err != nil {
-		return fmt.Errorf("error registering process collector: %w", err)
+		return nil, fmt.Errorf("error registering process collector: %w", err)
 	}
 	err = reg.Register(
 		collectors.NewGoCollector(),
 	)
 	if err != nil {
-		return fmt.Errorf("error registering go collector: %w", err)
+		return nil, fmt.Errorf("error registering go collector: %w", err)
 	}
-	return nil
+	return c, nil
 }
 
 func main() {
 	flag.Parse()
 
+	if flag.Arg(0) == "dump" {
+		if err := dump(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *runAsGroup != "" && *runAsUser == "" {
+		log.Fatal("-group requires -user")
+	}
+
 	reg := prometheus.NewPedanticRegistry()
-	err := setup(reg)
+	c, err := setup(reg)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *runAsUser != "" {
+		if err := dropPrivileges(*runAsUser, *runAsGroup); err != nil {
+			log.Fatalf("refusing to continue, error dropping privileges: %v", err)
+		}
+	}
+
+	// Collections only start once privileges were dropped.
+	if *collectInterval > 0 {
+		go c.collectEvery(context.Background(), *collectInterval)
+	}
+
 	http.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
 	log.Fatal(http.ListenAndServe(*listenAddr, nil))
 }
@@ -29,6 +29,22 @@ in
           Port to listen on for the HTTP server.
         '';
       };
+
+      user = lib.mkOption {
+        type = lib.types.str;
+        default = "nobody";
+        description = lib.mdDoc ''
+          User the exporter switches to after opening /dev/zfs as root.
+        '';
+      };
+
+      group = lib.mkOption {
+        type = lib.types.str;
+        default = "nogroup";
+        description = lib.mdDoc ''
+          Group the exporter switches to after opening /dev/zfs as root.
+        '';
+      };
     };
   };
 
@@ -44,7 +60,7 @@ in
       wantedBy = [ "network.target" ];
       serviceConfig = {
         DynamicUser = "false";
-        ExecStart = "${pkgs.prometheus-zfs-exporter}/bin/prometheus-zfs-exporter --listen-addr ${cfg.listenAddress}:${builtins.toString cfg.port}";
+        ExecStart = "${pkgs.prometheus-zfs-exporter}/bin/prometheus-zfs-exporter --listen-addr ${cfg.listenAddress}:${builtins.toString cfg.port} --user ${cfg.user} --group ${cfg.group}";
         Restart = "always";
         RestartSec = "5";
       };
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector exports the state, properties and scan progress of a pool.
+type poolCollector struct {
+	// lastScrubs holds the time the last scrub of each pool completed. The scan stats only hold the last scan,
+	// so the completion of a scrub is lost once a resilver follows it.
+	lastScrubsMu sync.Mutex
+	lastScrubs   map[string]time.Time
+
+	poolState      *prometheus.Desc
+	poolErrorCount *prometheus.Desc
+
+	poolSize          *prometheus.Desc
+	poolAllocated     *prometheus.Desc
+	poolFree          *prometheus.Desc
+	poolCapacity      *prometheus.Desc
+	poolFragmentation *prometheus.Desc
+	poolExpandSize    *prometheus.Desc
+	poolFreeing       *prometheus.Desc
+	poolLeaked        *prometheus.Desc
+	poolDedupRatio    *prometheus.Desc
+	poolBcloneUsed    *prometheus.Desc
+	poolBcloneSaved   *prometheus.Desc
+	poolCheckpoint    *prometheus.Desc
+	poolReadOnly      *prometheus.Desc
+	poolInfo          *prometheus.Desc
+
+	poolScanState         *prometheus.Desc
+	poolScanStartTime     *prometheus.Desc
+	poolScanEndTime       *prometheus.Desc
+	poolScanToExamine     *prometheus.Desc
+	poolScanExamined      *prometheus.Desc
+	poolScanSkipped       *prometheus.Desc
+	poolScanProcessed     *prometheus.Desc
+	poolScanIssued        *prometheus.Desc
+	poolScanErrors        *prometheus.Desc
+	poolScanPassRate      *prometheus.Desc
+	poolScanPaused        *prometheus.Desc
+	poolLastScrubComplete *prometheus.Desc
+}
+
+func newPoolCollector(config collectorConfig) Collector {
+	return &poolCollector{}
+}
+
+func (c *poolCollector) Describe(ch *chan<- *prometheus.Desc) {
+	describe(ch, &c.poolState, prometheus.NewDesc("zfs_pool_state", "", []string{"pool", "state"}, nil))
+	describe(ch, &c.poolErrorCount, prometheus.NewDesc("zfs_pool_error_count", "", []string{"pool"}, nil))
+
+	describe(ch, &c.poolSize, prometheus.NewDesc("zfs_pool_size", "Total size of the pool in bytes", []string{"pool"}, nil))
+	describe(ch, &c.poolAllocated, prometheus.NewDesc("zfs_pool_allocated", "Space allocated in the pool in bytes", []string{"pool"}, nil))
+	describe(ch, &c.poolFree, prometheus.NewDesc("zfs_pool_free", "Free space in the pool in bytes", []string{"pool"}, nil))
+	describe(ch, &c.poolCapacity, prometheus.NewDesc("zfs_pool_capacity", "Percentage of the pool space used", []string{"pool"}, nil))
+	describe(ch, &c.poolFragmentation, prometheus.NewDesc("zfs_pool_fragmentation", "Percentage of fragmentation of the free space in the pool", []string{"pool"}, nil))
+	describe(ch, &c.poolExpandSize, prometheus.NewDesc("zfs_pool_expand_size", "Space in bytes the pool could be expanded by", []string{"pool"}, nil))
+	describe(ch, &c.poolFreeing, prometheus.NewDesc("zfs_pool_freeing", "Space in bytes still to be freed from destroyed datasets", []string{"pool"}, nil))
+	describe(ch, &c.poolLeaked, prometheus.NewDesc("zfs_pool_leaked", "Space in bytes leaked by destroyed datasets", []string{"pool"}, nil))
+	describe(ch, &c.poolDedupRatio, prometheus.NewDesc("zfs_pool_dedup_ratio", "Deduplication ratio of the pool", []string{"pool"}, nil))
+	describe(ch, &c.poolBcloneUsed, prometheus.NewDesc("zfs_pool_bclone_used", "Space in bytes used by cloned blocks", []string{"pool"}, nil))
+	describe(ch, &c.poolBcloneSaved, prometheus.NewDesc("zfs_pool_bclone_saved", "Space in bytes saved by block cloning", []string{"pool"}, nil))
+	describe(ch, &c.poolCheckpoint, prometheus.NewDesc("zfs_pool_checkpoint", "Space in bytes used by the pool checkpoint", []string{"pool"}, nil))
+	describe(ch, &c.poolReadOnly, prometheus.NewDesc("zfs_pool_readonly", "Whether the pool is imported read-only", []string{"pool"}, nil))
+	describe(ch, &c.poolInfo, prometheus.NewDesc("zfs_pool_info", "String properties of the pool", []string{"pool", "altroot", "comment", "failmode", "autotrim", "autoexpand"}, nil))
+
+	describe(ch, &c.poolScanState, prometheus.NewDesc("zfs_pool_scan_state", "State of the last scrub or resilver of the pool", []string{"pool", "function", "state"}, nil))
+	describe(ch, &c.poolScanStartTime, prometheus.NewDesc("zfs_pool_scan_start_time_seconds", "Start time of the last scan as unix timestamp", []string{"pool", "function"}, nil))
+	describe(ch, &c.poolScanEndTime, prometheus.NewDesc("zfs_pool_scan_end_time_seconds", "End time of the last scan as unix timestamp, 0 while the scan is running", []string{"pool", "function"}, nil))
+	describe(ch, &c.poolScanToExamine, prometheus.NewDesc("zfs_pool_scan_to_examine_bytes", "Total bytes to scan", []string{"pool", "function"}, nil))
+	describe(ch, &c.poolScanExamined, prometheus.NewDesc("zfs_pool_scan_examined_bytes", "Bytes located by the scanner", []string{"pool", "function"}, nil))
+	describe(ch, &c.poolScanSkipped, prometheus.NewDesc("zfs_pool_scan_skipped_bytes", "Bytes skipped by the scanner", []string{"pool", "function"}, nil))
+	describe(ch, &c.poolScanProcessed, prometheus.NewDesc("zfs_pool_scan_processed_bytes", "Bytes repaired or resilvered by the scan", []string{"pool", "function"}, nil))
+	describe(ch, &c.poolScanIssued, prometheus.NewDesc("zfs_pool_scan_issued_bytes", "Bytes checked by the scanner", []string{"pool", "function"}, nil))
+	describe(ch, &c.poolScanErrors, prometheus.NewDesc("zfs_pool_scan_errors", "Errors encountered by the scan", []string{"pool", "function"}, nil))
+	describe(ch, &c.poolScanPassRate, prometheus.NewDesc("zfs_pool_scan_pass_rate_bytes_per_second", "Rate at which the running scan issues I/O", []string{"pool", "function"}, nil))
+	describe(ch, &c.poolScanPaused, prometheus.NewDesc("zfs_pool_scan_paused", "Whether the running scrub is paused", []string{"pool", "function"}, nil))
+	describe(ch, &c.poolLastScrubComplete, prometheus.NewDesc("zfs_pool_last_scrub_completed_timestamp_seconds", "Time the last scrub of the pool completed as unix timestamp, kept after a resilver while the exporter runs", []string{"pool"}, nil))
+}
+
+func (c *poolCollector) Update(ch *chan<- prometheus.Metric, pool *poolScrape) error {
+	poolStats, err := pool.Stats()
+	if err != nil {
+		return err
+	}
+
+	state := ioctl.PoolStateString(poolStats.State)
+	for _, poolState := range ioctl.PoolStates {
+		val := 0.0
+		if poolState == state {
+			val = 1.0
+		}
+		metric, err := prometheus.NewConstMetric(c.poolState, prometheus.GaugeValue, val, pool.name, poolState)
+		if err != nil {
+			return err
+		}
+		if ch != nil {
+			*ch <- metric
+		}
+	}
+
+	metric, err := prometheus.NewConstMetric(c.poolErrorCount, prometheus.CounterValue, float64(poolStats.ErrorCount), pool.name)
+	if err != nil {
+		return err
+	}
+	if ch != nil {
+		*ch <- metric
+	}
+
+	err = c.handlePoolProps(ch, pool)
+	if err != nil {
+		return err
+	}
+
+	// Pools that were never scrubbed or resilvered have no scan stats.
+	if poolStats.VdevTree != nil && poolStats.VdevTree.ScanStats != nil {
+		err = c.handleScanStats(ch, pool.name, ioctl.ParseScanStats(poolStats.VdevTree.ScanStats), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
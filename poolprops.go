@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// handlePoolProps exports the numeric properties of a pool as gauges and its string properties as labels of
+// zfs_pool_info.
+func (c *poolCollector) handlePoolProps(ch *chan<- prometheus.Metric, pool *poolScrape) error {
+	props, err := pool.zfs.PoolProps(pool.ctx, pool.name)
+	if err != nil {
+		return fmt.Errorf("error getting properties of pool %q: %w", pool.name, err)
+	}
+
+	labels := []string{pool.name}
+	gauges := []struct {
+		desc  *prometheus.Desc
+		prop  ioctl.PoolPropUint64
+		scale float64
+	}{
+		{c.poolSize, props.Size, 1},
+		{c.poolAllocated, props.Allocated, 1},
+		{c.poolFree, props.Free, 1},
+		{c.poolCapacity, props.Capacity, 1},
+		{c.poolFragmentation, props.Fragmentation, 1},
+		{c.poolExpandSize, props.ExpandSize, 1},
+		{c.poolFreeing, props.Freeing, 1},
+		{c.poolLeaked, props.Leaked, 1},
+		{c.poolDedupRatio, props.DedupRatio, 100},
+		{c.poolBcloneUsed, props.BcloneUsed, 1},
+		{c.poolBcloneSaved, props.BcloneSaved, 1},
+		{c.poolCheckpoint, props.Checkpoint, 1},
+		{c.poolReadOnly, props.ReadOnly, 1},
+	}
+	for _, g := range gauges {
+		// Properties unknown to the kernel module are missing, ZFS uses UINT64_MAX for values that are not
+		// available, like the fragmentation of a pool without spacemap histograms.
+		if g.prop.Source == 0 || g.prop.Value == math.MaxUint64 {
+			continue
+		}
+		if err := export(ch, g.desc, prometheus.GaugeValue, float64(g.prop.Value)/g.scale, labels); err != nil {
+			return err
+		}
+	}
+
+	info := []string{pool.name, props.AltRoot.Value, props.Comment.Value, "", "", ""}
+	if props.FailMode.Source != 0 {
+		info[3] = ioctl.FailModeString(props.FailMode.Value)
+	}
+	if props.Autotrim.Source != 0 {
+		info[4] = ioctl.OnOffString(props.Autotrim.Value)
+	}
+	if props.Autoexpand.Source != 0 {
+		info[5] = ioctl.OnOffString(props.Autoexpand.Value)
+	}
+	return export(ch, c.poolInfo, prometheus.GaugeValue, 1, info)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// dropPrivileges switches to userName and groupName after /dev/zfs was opened. If groupName is empty, the
+// primary group of the user is used. Switching from root to another user clears all capabilities, which is
+// verified afterwards, as the exporter must not continue with privileges it was asked to drop.
+func dropPrivileges(userName string, groupName string) error {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return fmt.Errorf("error looking up user %q: %w", userName, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("invalid uid %q of user %q", u.Uid, userName)
+	}
+	gidString := u.Gid
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return fmt.Errorf("error looking up group %q: %w", groupName, err)
+		}
+		gidString = g.Gid
+	}
+	gid, err := strconv.Atoi(gidString)
+	if err != nil {
+		return fmt.Errorf("invalid gid %q", gidString)
+	}
+
+	// The group has to be changed first, as the user afterwards lacks the privileges to do so. unix.Setgroups
+	// only changes the calling thread, syscall.Setgroups changes all threads like Setgid and Setuid.
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("error dropping supplementary groups: %w", err)
+	}
+	if err := unix.Setgid(gid); err != nil {
+		return fmt.Errorf("error switching to gid %d: %w", gid, err)
+	}
+	if err := unix.Setuid(uid); err != nil {
+		return fmt.Errorf("error switching to uid %d: %w", uid, err)
+	}
+	return checkPrivilegesDropped(uid, gid)
+}
+
+// checkPrivilegesDropped checks that the process runs with uid and gid only and without capabilities.
+func checkPrivilegesDropped(uid int, gid int) error {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return parseProcStatus(f, uid, gid)
+}
+
+// parseProcStatus checks the ids and capabilities in a /proc/<pid>/status file.
+func parseProcStatus(r io.Reader, uid int, gid int) error {
+	want := map[string]string{
+		"Uid":    strings.Repeat(strconv.Itoa(uid)+"\t", 4),
+		"Gid":    strings.Repeat(strconv.Itoa(gid)+"\t", 4),
+		"Groups": "",
+		"CapPrm": "0000000000000000",
+		"CapEff": "0000000000000000",
+		"CapAmb": "0000000000000000",
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		key, value, _ := strings.Cut(s.Text(), ":")
+		expected, ok := want[key]
+		if !ok {
+			continue
+		}
+		if strings.TrimSpace(value) != strings.TrimSpace(expected) {
+			return fmt.Errorf("privileges not dropped, %s is %q", key, strings.TrimSpace(value))
+		}
+		delete(want, key)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	// CapAmb is missing on kernels older than 4.3.
+	delete(want, "CapAmb")
+	for key := range want {
+		return fmt.Errorf("unable to verify that privileges were dropped, %s is missing", key)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProcStatus(t *testing.T) {
+	status := func(uid string, capEff string) string {
+		return "Name:\tprometheus-zfs\nUid:\t" + uid + "\nGid:\t65534\t65534\t65534\t65534\nGroups:\t\n" +
+			"CapInh:\t0000000000000000\nCapPrm:\t0000000000000000\nCapEff:\t" + capEff + "\n" +
+			"CapBnd:\t000001ffffffffff\nCapAmb:\t0000000000000000\n"
+	}
+	tests := []struct {
+		status string
+		ok     bool
+	}{
+		{status("65534\t65534\t65534\t65534", "0000000000000000"), true},
+		// The saved uid is still root, the process could switch back.
+		{status("65534\t65534\t0\t65534", "0000000000000000"), false},
+		{status("65534\t65534\t65534\t65534", "0000000000200000"), false},
+		{"Uid:\t65534\t65534\t65534\t65534\n", false},
+	}
+	for _, test := range tests {
+		err := parseProcStatus(strings.NewReader(test.status), 65534, 65534)
+		if (err == nil) != test.ok {
+			t.Errorf("got %v for status:\n%s", err, test.status)
+		}
+	}
+}
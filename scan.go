@@ -0,0 +1,93 @@
+package main
+
+import (
+	"time"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// unixSeconds returns t as unix timestamp, or 0 for the zero time.
+func unixSeconds(t time.Time) float64 {
+	if t.IsZero() {
+		return 0
+	}
+	return float64(t.Unix())
+}
+
+// handleScanStats exports the progress of the last scrub or resilver of a pool. The pass rate is computed
+// at now.
+func (c *poolCollector) handleScanStats(ch *chan<- prometheus.Metric, pool string, scan ioctl.ScanStats, now time.Time) error {
+	if err := exportEnum(ch, c.poolScanState, ioctl.ScanStates[:], scan.State, []string{pool, scan.Func}); err != nil {
+		return err
+	}
+
+	labels := []string{pool, scan.Func}
+	if err := export(ch, c.poolScanStartTime, prometheus.GaugeValue, unixSeconds(scan.StartTime), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.poolScanEndTime, prometheus.GaugeValue, unixSeconds(scan.EndTime), labels); err != nil {
+		return err
+	}
+
+	if err := export(ch, c.poolScanToExamine, prometheus.GaugeValue, float64(scan.ToExamine), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.poolScanExamined, prometheus.GaugeValue, float64(scan.Examined), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.poolScanSkipped, prometheus.GaugeValue, float64(scan.Skipped), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.poolScanProcessed, prometheus.GaugeValue, float64(scan.Processed), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.poolScanIssued, prometheus.GaugeValue, float64(scan.Issued), labels); err != nil {
+		return err
+	}
+	if err := export(ch, c.poolScanErrors, prometheus.GaugeValue, float64(scan.Errors), labels); err != nil {
+		return err
+	}
+
+	if scan.State == "SCANNING" {
+		if err := export(ch, c.poolScanPassRate, prometheus.GaugeValue, scan.PassRate(now), labels); err != nil {
+			return err
+		}
+		paused := 0.0
+		if scan.Paused() {
+			paused = 1.0
+		}
+		if err := export(ch, c.poolScanPaused, prometheus.GaugeValue, paused, labels); err != nil {
+			return err
+		}
+	}
+
+	if scan.Func == "SCRUB" && scan.State == "FINISHED" {
+		c.setLastScrub(pool, scan.EndTime)
+	}
+	if lastScrub, ok := c.lastScrub(pool); ok {
+		if err := export(ch, c.poolLastScrubComplete, prometheus.GaugeValue, unixSeconds(lastScrub), []string{pool}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lastScrub returns the time the last scrub of pool seen by the exporter completed.
+func (c *poolCollector) lastScrub(pool string) (time.Time, bool) {
+	c.lastScrubsMu.Lock()
+	defer c.lastScrubsMu.Unlock()
+	t, ok := c.lastScrubs[pool]
+	return t, ok
+}
+
+// setLastScrub records that the last scrub of pool completed at t.
+func (c *poolCollector) setLastScrub(pool string, t time.Time) {
+	c.lastScrubsMu.Lock()
+	defer c.lastScrubsMu.Unlock()
+	if c.lastScrubs == nil {
+		c.lastScrubs = make(map[string]time.Time)
+	}
+	c.lastScrubs[pool] = t
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// snapshot holds the metrics of a collection. It is never modified, so that it can be served to any number of
+// scrapes.
+type snapshot struct {
+	metrics []prometheus.Metric
+	// timestamp is the time the collection finished.
+	timestamp time.Time
+}
+
+// collection is a collection in progress. Scrapes arriving while it runs wait for its snapshot instead of
+// issuing the same ioctls again.
+type collection struct {
+	done     chan struct{}
+	snapshot *snapshot
+}
+
+// collectSnapshot collects all metrics into a new snapshot, or waits for the collection in progress.
+func (c *zfsCollector) collectSnapshot() *snapshot {
+	c.collectionMu.Lock()
+	if running := c.collection; running != nil {
+		c.collectionMu.Unlock()
+		<-running.done
+		return running.snapshot
+	}
+	running := &collection{done: make(chan struct{})}
+	c.collection = running
+	c.collectionMu.Unlock()
+
+	running.snapshot = c.newSnapshot()
+
+	c.collectionMu.Lock()
+	c.collection = nil
+	c.collectionMu.Unlock()
+	close(running.done)
+	return running.snapshot
+}
+
+func (c *zfsCollector) newSnapshot() *snapshot {
+	metrics := make(chan prometheus.Metric)
+	s := &snapshot{}
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		for metric := range metrics {
+			s.metrics = append(s.metrics, metric)
+		}
+	}()
+
+	ch := (chan<- prometheus.Metric)(metrics)
+	if err := c.collect(&ch); err != nil {
+		slog.Error("error collecting and exporting zfs metrics", "error", err)
+	}
+	close(metrics)
+	<-received
+	s.timestamp = time.Now()
+	return s
+}
+
+// collectEvery collects a snapshot right away and then every interval until ctx is done. Collect serves the
+// latest of them instead of collecting on every scrape.
+func (c *zfsCollector) collectEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.latest.Store(c.collectSnapshot())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
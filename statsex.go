@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// exportLatencyHistogram exports a ZFS latency histogram, whose bucket i counts the I/Os that took less than
+// 2^(i+1) nanoseconds, as classic or native histogram in seconds. ZFS does not track the sum of the
+// latencies, so the sum is NaN. created is the time the vdev was loaded and its histograms were reset.
+func (c *vdevCollector) exportLatencyHistogram(ch *chan<- prometheus.Metric, desc *prometheus.Desc, histogram []uint64, created time.Time, labels []string) error {
+	var metric prometheus.Metric
+	var err error
+	count := uint64(0)
+	if c.nativeHistograms {
+		// Native histograms have their bucket boundaries at powers of two seconds. Bucket k of schema 0
+		// ends at 2^k seconds, 2^-30 seconds is within 7% of a nanosecond.
+		buckets := make(map[int]int64)
+		for i, n := range histogram {
+			if n != 0 {
+				buckets[i+1-30] = int64(n)
+			}
+			count += n
+		}
+		metric, err = prometheus.NewConstNativeHistogram(desc, count, math.NaN(), buckets, nil, 0, 0, 0, created, labels...)
+	} else {
+		buckets := make(map[float64]uint64, len(histogram))
+		for i, n := range histogram {
+			count += n
+			buckets[math.Ldexp(1e-9, i+1)] = count
+		}
+		metric, err = prometheus.NewConstHistogram(desc, count, math.NaN(), buckets, labels...)
+	}
+	if err != nil {
+		return err
+	}
+	if ch != nil {
+		*ch <- metric
+	}
+	return nil
+}
+
+// handleVdevStatsEx exports the latency histograms and queue depths of a vdev.
+func (c *vdevCollector) handleVdevStatsEx(ch *chan<- prometheus.Metric, labels []string, ex *ioctl.VdevStatsEx, created time.Time) error {
+	ioTypes := []struct {
+		name  string
+		total []uint64
+		disk  []uint64
+	}{
+		{"read", ex.TotalReadLatency, ex.DiskReadLatency},
+		{"write", ex.TotalWriteLatency, ex.DiskWriteLatency},
+	}
+	for _, t := range ioTypes {
+		l := append(labels[:len(labels):len(labels)], t.name)
+		if t.total != nil {
+			if err := c.exportLatencyHistogram(ch, c.poolVdevTotalLatency, t.total, created, l); err != nil {
+				return err
+			}
+		}
+		if t.disk != nil {
+			if err := c.exportLatencyHistogram(ch, c.poolVdevDiskLatency, t.disk, created, l); err != nil {
+				return err
+			}
+		}
+	}
+
+	ioClasses := []struct {
+		name    string
+		active  uint64
+		pending uint64
+		latency []uint64
+	}{
+		{"sync_read", ex.SyncReadActive, ex.SyncReadPending, ex.SyncReadQueueLatency},
+		{"sync_write", ex.SyncWriteActive, ex.SyncWritePending, ex.SyncWriteQueueLatency},
+		{"async_read", ex.AsyncReadActive, ex.AsyncReadPending, ex.AsyncReadQueueLatency},
+		{"async_write", ex.AsyncWriteActive, ex.AsyncWritePending, ex.AsyncWriteQueueLatency},
+		{"scrub", ex.ScrubActive, ex.ScrubPending, ex.ScrubQueueLatency},
+		{"trim", ex.TrimActive, ex.TrimPending, ex.TrimQueueLatency},
+		{"rebuild", ex.RebuildActive, ex.RebuildPending, ex.RebuildQueueLatency},
+	}
+	for _, class := range ioClasses {
+		l := append(labels[:len(labels):len(labels)], class.name)
+		// Kernel modules that don't know the class don't return its histogram.
+		if class.latency == nil {
+			continue
+		}
+		if err := c.exportLatencyHistogram(ch, c.poolVdevQueueLatency, class.latency, created, l); err != nil {
+			return err
+		}
+		if err := export(ch, c.poolVdevQueueActive, prometheus.GaugeValue, float64(class.active), l); err != nil {
+			return err
+		}
+		if err := export(ch, c.poolVdevQueuePending, prometheus.GaugeValue, float64(class.pending), l); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
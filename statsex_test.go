@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// collectStatsEx returns the metrics exported for ex keyed by their descriptor and io_type/io_class label.
+func collectStatsEx(t *testing.T, native bool, ex *ioctl.VdevStatsEx) map[string]*dto.Metric {
+	c := &vdevCollector{nativeHistograms: native}
+	c.Describe(nil)
+
+	metrics := make(chan prometheus.Metric, 100)
+	ch := (chan<- prometheus.Metric)(metrics)
+	if err := c.handleVdevStatsEx(&ch, []string{"tank", "sda", "disk"}, ex, time.Unix(1700000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	close(metrics)
+
+	names := map[*prometheus.Desc]string{
+		c.poolVdevTotalLatency: "total",
+		c.poolVdevDiskLatency:  "disk",
+		c.poolVdevQueueLatency: "queue",
+		c.poolVdevQueueActive:  "active",
+		c.poolVdevQueuePending: "pending",
+	}
+	got := make(map[string]*dto.Metric)
+	for metric := range metrics {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range m.Label {
+			if l.GetName() == "io_type" || l.GetName() == "io_class" {
+				got[names[metric.Desc()]+"/"+l.GetValue()] = m
+			}
+		}
+	}
+	return got
+}
+
+func TestVdevStatsEx(t *testing.T) {
+	histogram := make([]uint64, 37)
+	histogram[0] = 1  // [1ns, 2ns)
+	histogram[20] = 3 // [~1ms, ~2ms)
+	ex := &ioctl.VdevStatsEx{
+		DiskReadLatency:   histogram,
+		ScrubActive:       2,
+		ScrubPending:      5,
+		ScrubQueueLatency: histogram,
+	}
+
+	got := collectStatsEx(t, false, ex)
+	if len(got) != 4 {
+		t.Errorf("got %d metrics, want disk read latency and 3 scrub metrics", len(got))
+	}
+	h := got["disk/read"].GetHistogram()
+	if h.GetSampleCount() != 4 || !math.IsNaN(h.GetSampleSum()) {
+		t.Errorf("got count %d sum %v, want 4 NaN", h.GetSampleCount(), h.GetSampleSum())
+	}
+	for _, b := range h.Bucket {
+		want := uint64(1)
+		if b.GetUpperBound() >= 1<<21*1e-9 {
+			want = 4
+		}
+		if b.GetCumulativeCount() != want {
+			t.Errorf("got %d for bucket le=%v, want %d", b.GetCumulativeCount(), b.GetUpperBound(), want)
+		}
+	}
+	if got["active/scrub"].GetGauge().GetValue() != 2 || got["pending/scrub"].GetGauge().GetValue() != 5 {
+		t.Errorf("unexpected scrub queue depths %v %v", got["active/scrub"], got["pending/scrub"])
+	}
+
+	got = collectStatsEx(t, true, ex)
+	h = got["queue/scrub"].GetHistogram()
+	if h.GetSchema() != 0 || h.GetSampleCount() != 4 || len(h.Bucket) != 0 {
+		t.Errorf("expected native histogram, got %v", h)
+	}
+	// Buckets -29 and -9 with counts 1 and 3, delta encoded.
+	if len(h.PositiveSpan) != 2 || h.PositiveSpan[0].GetOffset() != -29 || h.PositiveSpan[1].GetOffset() != 19 {
+		t.Errorf("unexpected spans %v", h.PositiveSpan)
+	}
+	if len(h.PositiveDelta) != 2 || h.PositiveDelta[0] != 1 || h.PositiveDelta[1] != 2 {
+		t.Errorf("unexpected deltas %v", h.PositiveDelta)
+	}
+}
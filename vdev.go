@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// vdevCollector exports the stats, latency histograms and queue depths of all vdevs of a pool.
+type vdevCollector struct {
+	// nativeHistograms exports histograms as native histograms instead of classic histograms.
+	nativeHistograms bool
+
+	poolVdevState           *prometheus.Desc
+	poolVdevInitializeState *prometheus.Desc
+	poolVdevTrimState       *prometheus.Desc
+	// poolVdevStats holds the descriptors of vdevStatMetrics.
+	poolVdevStats []*prometheus.Desc
+
+	poolVdevTotalLatency *prometheus.Desc
+	poolVdevDiskLatency  *prometheus.Desc
+	poolVdevQueueLatency *prometheus.Desc
+	poolVdevQueueActive  *prometheus.Desc
+	poolVdevQueuePending *prometheus.Desc
+
+	unsupportedField *prometheus.Desc
+}
+
+func newVdevCollector(config collectorConfig) Collector {
+	return &vdevCollector{nativeHistograms: config.nativeHistograms}
+}
+
+func (c *vdevCollector) Describe(ch *chan<- *prometheus.Desc) {
+	describe(ch, &c.poolVdevState, prometheus.NewDesc("zfs_pool_vdev_state", "", []string{"pool", "vdev", "vdev_type", "state"}, nil))
+	describe(ch, &c.poolVdevInitializeState, prometheus.NewDesc("zfs_pool_vdev_initialize_state", "State of the initialization of the vdev", []string{"pool", "vdev", "vdev_type", "state"}, nil))
+	describe(ch, &c.poolVdevTrimState, prometheus.NewDesc("zfs_pool_vdev_trim_state", "State of the manual trim of the vdev", []string{"pool", "vdev", "vdev_type", "state"}, nil))
+	c.poolVdevStats = make([]*prometheus.Desc, len(vdevStatMetrics))
+	for i, m := range vdevStatMetrics {
+		describe(ch, &c.poolVdevStats[i], prometheus.NewDesc(m.name, m.help, []string{"pool", "vdev", "vdev_type"}, nil))
+	}
+
+	describe(ch, &c.poolVdevTotalLatency, prometheus.NewDesc("zfs_pool_vdev_total_latency_seconds", "Total latency of I/Os including queueing, the sum is not tracked by ZFS", []string{"pool", "vdev", "vdev_type", "io_type"}, nil))
+	describe(ch, &c.poolVdevDiskLatency, prometheus.NewDesc("zfs_pool_vdev_disk_latency_seconds", "Latency of I/Os on the disk, the sum is not tracked by ZFS", []string{"pool", "vdev", "vdev_type", "io_type"}, nil))
+	describe(ch, &c.poolVdevQueueLatency, prometheus.NewDesc("zfs_pool_vdev_queue_latency_seconds", "Time I/Os waited in the queue of their class, the sum is not tracked by ZFS", []string{"pool", "vdev", "vdev_type", "io_class"}, nil))
+	describe(ch, &c.poolVdevQueueActive, prometheus.NewDesc("zfs_pool_vdev_queue_active", "I/Os of the class currently issued to the disk", []string{"pool", "vdev", "vdev_type", "io_class"}, nil))
+	describe(ch, &c.poolVdevQueuePending, prometheus.NewDesc("zfs_pool_vdev_queue_pending", "I/Os of the class waiting in the queue", []string{"pool", "vdev", "vdev_type", "io_class"}, nil))
+
+	describe(ch, &c.unsupportedField, prometheus.NewDesc("zfs_exporter_unsupported_field", "Fields the kernel module of the pool does not provide", []string{"pool", "field"}, nil))
+}
+
+func (c *vdevCollector) Update(ch *chan<- prometheus.Metric, pool *poolScrape) error {
+	poolStats, err := pool.Stats()
+	if err != nil {
+		return err
+	}
+	if poolStats.VdevTree == nil {
+		return fmt.Errorf("pool %q has no vdev tree", pool.name)
+	}
+	err = c.handleVdev(ch, pool.zfsVersion, pool.name, "", poolStats.VdevTree)
+	if err != nil {
+		return err
+	}
+	return c.handleUnsupportedVdevStats(ch, pool.zfsVersion, pool.name, poolStats.VdevTree.Stats)
+}
+
+func (c *vdevCollector) handleVdev(ch *chan<- prometheus.Metric, zfsVersion string, pool string, vdevNamePrefix string, vdev *ioctl.Vdev) error {
+	vdevName := ""
+	if vdev.Path != "" {
+		p := path.Base(vdev.Path)
+		vdevName = p
+	} else {
+		if vdev.Type == "root" {
+			vdevName = pool
+		} else {
+			vdevName = fmt.Sprintf("%s-%d", vdev.Type, vdev.ID)
+		}
+	}
+	vdevName = vdevNamePrefix + vdevName
+
+	labels := []string{pool, vdevName, vdev.Type}
+
+	if vdev.Stats != nil {
+		if err := c.handleVdevStats(ch, zfsVersion, labels, vdev.Stats); err != nil {
+			return err
+		}
+	}
+
+	if vdev.StatsEx != nil {
+		var age time.Duration
+		if len(vdev.Stats) > ioctl.VDevStats_vs_timestamp {
+			age = time.Duration(vdev.Stats[ioctl.VDevStats_vs_timestamp])
+		}
+		if err := c.handleVdevStatsEx(ch, labels, vdev.StatsEx, time.Now().Add(-age)); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range vdev.AllChildren() {
+		err := c.handleVdev(ch, zfsVersion, pool, vdevName+"/", child)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// vdevStatMetric exports a field of vdev_stats. Fields the kernel module does not return are skipped.
+type vdevStatMetric struct {
+	field     int
+	name      string
+	help      string
+	valueType prometheus.ValueType
+}
+
+var vdevStatMetrics = []vdevStatMetric{
+	{ioctl.VDevStats_vs_alloc, "zfs_pool_vdev_alloc_space", "", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_space, "zfs_pool_vdev_total_space", "", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_dspace, "zfs_pool_vdev_def_space", "", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_rsize, "zfs_pool_vdev_rep_dev_size", "", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_esize, "zfs_pool_vdev_expand_size", "Space in bytes the vdev could be expanded by", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_pspace, "zfs_pool_vdev_phys_space", "", prometheus.GaugeValue},
+
+	{ioctl.VDevStats_vs_ops_read, "zfs_pool_vdev_read_ops", "", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_ops_write, "zfs_pool_vdev_write_ops", "", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_ops_free, "zfs_pool_vdev_free_ops", "Free operations issued to the vdev", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_ops_claim, "zfs_pool_vdev_claim_ops", "Claim operations issued to the vdev", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_ops_flush, "zfs_pool_vdev_flush_ops", "Cache flushes issued to the vdev", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_bytes_read, "zfs_pool_vdev_read_bytes", "", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_bytes_write, "zfs_pool_vdev_write_bytes", "", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_bytes_free, "zfs_pool_vdev_free_bytes", "Bytes freed on the vdev", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_bytes_claim, "zfs_pool_vdev_claim_bytes", "Bytes claimed on the vdev", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_bytes_flush, "zfs_pool_vdev_flush_bytes", "Bytes of cache flushes issued to the vdev", prometheus.CounterValue},
+
+	{ioctl.VDevStats_vs_read_errors, "zfs_pool_vdev_read_errors", "", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_write_errors, "zfs_pool_vdev_write_errors", "", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_checksum_errors, "zfs_pool_vdev_checksum_errors", "", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_slow_ios, "zfs_pool_vdev_slow_ios", "", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_dio_verify_errors, "zfs_pool_vdev_dio_verify_errors", "Direct I/O writes whose checksum could not be verified", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_self_healed, "zfs_pool_vdev_self_healed_bytes", "Bytes repaired on the vdev", prometheus.CounterValue},
+
+	{ioctl.VDevStats_vs_scan_removing, "zfs_pool_vdev_scan_removing", "Whether the vdev is being removed", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_scan_processed, "zfs_pool_vdev_scan_processed_bytes", "Bytes processed on the vdev by the running scan", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_rebuild_processed, "zfs_pool_vdev_rebuild_processed_bytes", "Bytes rebuilt on the vdev by the running sequential resilver", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_resilver_deferred, "zfs_pool_vdev_resilver_deferred", "Whether a resilver of the vdev is deferred", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_fragmentation, "zfs_pool_vdev_fragmentation", "Percentage of fragmentation of the free space on the vdev", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_checkpoint_space, "zfs_pool_vdev_checkpoint_space", "Space in bytes used by the pool checkpoint on the vdev", prometheus.GaugeValue},
+
+	{ioctl.VDevStats_vs_initialize_errors, "zfs_pool_vdev_initialize_errors", "Errors while initializing the vdev", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_initialize_bytes_done, "zfs_pool_vdev_initialize_bytes_done", "Bytes initialized on the vdev", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_initialize_bytes_est, "zfs_pool_vdev_initialize_bytes_est", "Total bytes to initialize on the vdev", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_initialize_action_time, "zfs_pool_vdev_initialize_action_time_seconds", "Time the initialization of the vdev was last started, suspended or completed as unix timestamp", prometheus.GaugeValue},
+
+	{ioctl.VDevStats_vs_trim_errors, "zfs_pool_vdev_trim_errors", "Errors while trimming the vdev", prometheus.CounterValue},
+	{ioctl.VDevStats_vs_trim_notsup, "zfs_pool_vdev_trim_notsup", "Whether the vdev does not support trim", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_trim_bytes_done, "zfs_pool_vdev_trim_bytes_done", "Bytes trimmed on the vdev by the manual trim", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_trim_bytes_est, "zfs_pool_vdev_trim_bytes_est", "Total bytes to trim on the vdev by the manual trim", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_trim_action_time, "zfs_pool_vdev_trim_action_time_seconds", "Time the manual trim of the vdev was last started, suspended or completed as unix timestamp", prometheus.GaugeValue},
+
+	{ioctl.VDevStats_vs_configured_ashift, "zfs_pool_vdev_configured_ashift", "Configured ashift of the vdev", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_logical_ashift, "zfs_pool_vdev_logical_ashift", "Logical sector size of the vdev as power of two", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_physical_ashift, "zfs_pool_vdev_physical_ashift", "Physical sector size of the vdev as power of two", prometheus.GaugeValue},
+	{ioctl.VDevStats_vs_noalloc, "zfs_pool_vdev_noalloc", "Whether allocations on the vdev are halted", prometheus.GaugeValue},
+}
+
+// exportEnum exports a gauge for every state, which is 1 for the current state and 0 for all others.
+func exportEnum(ch *chan<- prometheus.Metric, desc *prometheus.Desc, states []string, state string, labels []string) error {
+	for _, s := range states {
+		val := 0.0
+		if s == state {
+			val = 1.0
+		}
+		if err := export(ch, desc, prometheus.GaugeValue, val, append(labels[:len(labels):len(labels)], s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleVdevStats exports the vdev_stats array of a vdev, returned by the kernel module version zfsVersion.
+func (c *vdevCollector) handleVdevStats(ch *chan<- prometheus.Metric, zfsVersion string, labels []string, stats []uint64) error {
+	schema := ioctl.NewVdevStatsSchema(zfsVersion, len(stats))
+
+	if state, ok := schema.Get(stats, ioctl.VDevStats_vs_state); ok {
+		aux, _ := schema.Get(stats, ioctl.VDevStats_vs_aux)
+		if err := exportEnum(ch, c.poolVdevState, ioctl.VDevStates[:], ioctl.VDevStateString(state, aux), labels); err != nil {
+			return err
+		}
+	}
+	if state, ok := schema.Get(stats, ioctl.VDevStats_vs_initialize_state); ok {
+		if err := exportEnum(ch, c.poolVdevInitializeState, ioctl.VDevOperationStates[:], ioctl.VDevOperationStateString(state), labels); err != nil {
+			return err
+		}
+	}
+	if state, ok := schema.Get(stats, ioctl.VDevStats_vs_trim_state); ok {
+		if err := exportEnum(ch, c.poolVdevTrimState, ioctl.VDevOperationStates[:], ioctl.VDevOperationStateString(state), labels); err != nil {
+			return err
+		}
+	}
+
+	for i, m := range vdevStatMetrics {
+		v, ok := schema.Get(stats, m.field)
+		if !ok {
+			continue
+		}
+		// ZFS uses UINT64_MAX for values that are not available, like the fragmentation of non top-level vdevs.
+		if m.valueType == prometheus.GaugeValue && v == math.MaxUint64 {
+			continue
+		}
+		if err := export(ch, c.poolVdevStats[i], m.valueType, float64(v), labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleUnsupportedVdevStats reports the vdev_stats fields the kernel module of a pool does not return.
+func (c *vdevCollector) handleUnsupportedVdevStats(ch *chan<- prometheus.Metric, zfsVersion string, pool string, stats []uint64) error {
+	for _, field := range ioctl.NewVdevStatsSchema(zfsVersion, len(stats)).Missing() {
+		if err := export(ch, c.unsupportedField, prometheus.GaugeValue, 1, []string{pool, field}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
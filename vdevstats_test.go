@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// vdevStatsCollector exports stats as the vdev_stats of the root vdev of the pool tank.
+type vdevStatsCollector struct {
+	vdevCollector
+	stats []uint64
+}
+
+func (c *vdevStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.vdevCollector.Describe(&ch)
+}
+
+func (c *vdevStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.handleVdevStats(&ch, "", []string{"tank", "tank", "root"}, c.stats)
+}
+
+func TestVdevStats(t *testing.T) {
+	stats := make([]uint64, ioctl.VDevStats_vs_dio_verify_errors+1)
+	for i := range stats {
+		stats[i] = uint64(1000 + i)
+	}
+	stats[ioctl.VDevStats_vs_state] = ioctl.StateDegraded
+	stats[ioctl.VDevStats_vs_trim_state] = 3
+	stats[ioctl.VDevStats_vs_fragmentation] = math.MaxUint64
+
+	got := gather(t, &vdevStatsCollector{stats: stats})
+	for _, want := range []string{
+		`zfs_pool_vdev_state{pool="tank",state="DEGRADED",vdev="tank",vdev_type="root"} 1`,
+		`zfs_pool_vdev_trim_state{pool="tank",state="SUSPENDED",vdev="tank",vdev_type="root"} 1`,
+		`zfs_pool_vdev_initialize_state{pool="tank",state="UNKNOWN",vdev="tank",vdev_type="root"} 1`,
+		`zfs_pool_vdev_read_bytes{pool="tank",vdev="tank",vdev_type="root"} 1015`,
+		`zfs_pool_vdev_read_errors{pool="tank",vdev="tank",vdev_type="root"} 1020`,
+		`zfs_pool_vdev_slow_ios{pool="tank",vdev="tank",vdev_type="root"} 1034`,
+		`zfs_pool_vdev_dio_verify_errors{pool="tank",vdev="tank",vdev_type="root"} 1047`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("missing metric %s", want)
+		}
+	}
+	if strings.Contains(got, "zfs_pool_vdev_fragmentation{") {
+		t.Errorf("unavailable fragmentation should not be exported")
+	}
+
+	// Kernel modules before 2.2 do not return the physical space and later fields.
+	got = gather(t, &vdevStatsCollector{stats: stats[:ioctl.VDevStats_vs_pspace]})
+	if !strings.Contains(got, "zfs_pool_vdev_noalloc{") || strings.Contains(got, "zfs_pool_vdev_phys_space{") {
+		t.Errorf("expected only the fields returned by the kernel module, got\n%s", got)
+	}
+}
+
+// unsupportedCollector reports the vdev_stats fields missing from stats, returned by the kernel module
+// version, for the pool tank.
+type unsupportedCollector struct {
+	vdevCollector
+	version string
+	stats   []uint64
+}
+
+func (c *unsupportedCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.vdevCollector.Describe(&ch)
+}
+
+func (c *unsupportedCollector) Collect(ch chan<- prometheus.Metric) {
+	c.handleUnsupportedVdevStats(&ch, c.version, "tank", c.stats)
+	c.handleVdevStats(&ch, c.version, []string{"tank", "tank", "root"}, c.stats)
+}
+
+func TestUnsupportedVdevStats(t *testing.T) {
+	stats := make([]uint64, 27)
+	for i := range stats {
+		stats[i] = uint64(1000 + i)
+	}
+	got := gather(t, &unsupportedCollector{version: "0.7.13-1", stats: stats})
+	for _, want := range []string{
+		`zfs_exporter_unsupported_field{field="vs_initialize_errors",pool="tank"} 1`,
+		`zfs_exporter_unsupported_field{field="vs_slow_ios",pool="tank"} 1`,
+		`zfs_pool_vdev_self_healed_bytes{pool="tank",vdev="tank",vdev_type="root"} 1023`,
+		`zfs_pool_vdev_fragmentation{pool="tank",vdev="tank",vdev_type="root"} 1026`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("missing metric %s", want)
+		}
+	}
+	if strings.Contains(got, `field="vs_self_healed"`) || strings.Contains(got, "zfs_pool_vdev_trim_state{") {
+		t.Errorf("unexpected metrics in\n%s", got)
+	}
+}
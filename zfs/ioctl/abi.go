@@ -0,0 +1,70 @@
+package ioctl
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ABI is the zfs_cmd layout of an OpenZFS release. Sending a Cmd with the layout of a different release makes
+// the kernel read and write fields at the wrong offsets, so a ZFSHandle only issues ioctls with the ABI of the
+// loaded kernel module.
+//
+// The ioctl numbering is not part of the ABI. From 0.8 to 2.3 new ioctls were only ever appended to the legacy
+// range ZFS_IOC_POOL_CREATE to ZFS_IOC_POOL_SYNC and to the platform range, so the numbers of types.go hold for
+// all supported releases. Ioctls added after the loaded release are rejected by the kernel.
+type ABI struct {
+	// Version is the major and minor version of the release, e.g. 2.2.
+	Version string
+
+	// cmdSize is sizeof(zfs_cmd_t). The kernel copies this many bytes from and to the Cmd of every ioctl.
+	cmdSize uintptr
+}
+
+// cmdV2_2 is the zfs_cmd layout since OpenZFS 2.2, which appended the zone of the caller to the layout of
+// Cmd.
+type cmdV2_2 struct {
+	Cmd
+	Zoneid uint64
+}
+
+// ABIs are the ABIs of the supported OpenZFS releases.
+var ABIs = []*ABI{
+	{Version: "0.8", cmdSize: unsafe.Sizeof(Cmd{})},
+	{Version: "2.0", cmdSize: unsafe.Sizeof(Cmd{})},
+	{Version: "2.1", cmdSize: unsafe.Sizeof(Cmd{})},
+	{Version: "2.2", cmdSize: unsafe.Sizeof(cmdV2_2{})},
+	{Version: "2.3", cmdSize: unsafe.Sizeof(cmdV2_2{})},
+}
+
+// ABIForVersion returns the ABI of the kernel module version, as read by ModuleVersion. It fails if the
+// release is not supported.
+func ABIForVersion(version string) (*ABI, error) {
+	major, minor, ok := parseModuleVersion(version)
+	if ok {
+		for _, abi := range ABIs {
+			if abi.Version == fmt.Sprintf("%d.%d", major, minor) {
+				return abi, nil
+			}
+		}
+	}
+
+	var supported []string
+	for _, abi := range ABIs {
+		supported = append(supported, abi.Version)
+	}
+	return nil, fmt.Errorf("unsupported zfs module version %q, supported versions are %s", version, strings.Join(supported, ", "))
+}
+
+// request returns the request number of ioctl. It fails with ENOTSUP for numbers outside of the legacy and
+// platform ranges, like ZFS_IOC_LAST.
+func (a *ABI) request(ioctl Ioctl) (uintptr, error) {
+	legacy := ioctl >= ZFS_IOC_POOL_CREATE && ioctl <= ZFS_IOC_POOL_SYNC
+	platform := ioctl > ZFS_IOC_PLATFORM && ioctl < ZFS_IOC_LAST
+	if !legacy && !platform {
+		return 0, fmt.Errorf("%v is not supported by zfs %s: %w", ioctl, a.Version, unix.ENOTSUP)
+	}
+	return uintptr(ioctl), nil
+}
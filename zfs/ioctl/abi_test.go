@@ -0,0 +1,140 @@
+package ioctl
+
+import (
+	"errors"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestCmdLayouts checks the layouts against sizeof and offsetof of zfs_cmd_t on 64-bit Linux.
+func TestCmdLayouts(t *testing.T) {
+	var cmd Cmd
+	var cmdV2_2 cmdV2_2
+	type offset struct {
+		field     string
+		got, want uintptr
+	}
+	tests := []struct {
+		name       string
+		size, want uintptr
+		offsets    []offset
+	}{
+		{"0.8", unsafe.Sizeof(cmd), 13736, []offset{
+			{"zc_name", unsafe.Offsetof(cmd.Name), 0},
+			{"zc_nvlist_src", unsafe.Offsetof(cmd.Nvlist_src), 4096},
+			{"zc_nvlist_src_size", unsafe.Offsetof(cmd.Nvlist_src_size), 4104},
+			{"zc_nvlist_dst", unsafe.Offsetof(cmd.Nvlist_dst), 4112},
+			{"zc_nvlist_dst_size", unsafe.Offsetof(cmd.Nvlist_dst_size), 4120},
+			{"zc_nvlist_dst_filled", unsafe.Offsetof(cmd.Nvlist_dst_filled), 4128},
+			{"zc_history", unsafe.Offsetof(cmd.History), 4136},
+			{"zc_value", unsafe.Offsetof(cmd.Value), 4144},
+			{"zc_string", unsafe.Offsetof(cmd.String), 12336},
+			{"zc_nvlist_conf", unsafe.Offsetof(cmd.Nvlist_conf), 12600},
+			{"zc_nvlist_conf_size", unsafe.Offsetof(cmd.Nvlist_conf_size), 12608},
+			{"zc_cookie", unsafe.Offsetof(cmd.Cookie), 12616},
+			{"zc_objset_stats", unsafe.Offsetof(cmd.Objset_stats), 12704},
+			{"zc_begin_record", unsafe.Offsetof(cmd.Begin_record), 12992},
+			{"zc_inject_record", unsafe.Offsetof(cmd.Inject_record), 13296},
+			{"zc_cleanup_fd", unsafe.Offsetof(cmd.Cleanup_fd), 13664},
+			{"zc_stat", unsafe.Offsetof(cmd.Stat), 13696},
+		}},
+		{"2.2", unsafe.Sizeof(cmdV2_2), 13744, []offset{
+			{"zc_name", unsafe.Offsetof(cmdV2_2.Cmd), 0},
+			{"zc_zoneid", unsafe.Offsetof(cmdV2_2.Zoneid), 13736},
+		}},
+	}
+	for _, test := range tests {
+		if test.size != test.want {
+			t.Errorf("%s: got size %d, want %d", test.name, test.size, test.want)
+		}
+		for _, o := range test.offsets {
+			if o.got != o.want {
+				t.Errorf("%s: got offset %d of %s, want %d", test.name, o.got, o.field, o.want)
+			}
+		}
+	}
+
+	for _, abi := range ABIs {
+		if abi.cmdSize != unsafe.Sizeof(cmd) && abi.cmdSize != unsafe.Sizeof(cmdV2_2) {
+			t.Errorf("%s: cmd size %d matches no layout", abi.Version, abi.cmdSize)
+		}
+	}
+}
+
+func TestABIForVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		cmdSize uintptr
+	}{
+		{"0.8.6-1", "0.8", 13736},
+		{"2.0.7-1", "2.0", 13736},
+		{"zfs-kmod-2.1.5-1", "2.1", 13736},
+		{"2.2.4-1", "2.2", 13744},
+		{"2.3.0-rc1", "2.3", 13744},
+		{"0.7.13-1", "", 0},
+		{"2.4.0-1", "", 0},
+		{"", "", 0},
+	}
+	for _, test := range tests {
+		abi, err := ABIForVersion(test.version)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q: got ABI %s, want error", test.version, abi.Version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.version, err)
+			continue
+		}
+		if abi.Version != test.want || abi.cmdSize != test.cmdSize {
+			t.Errorf("%q: got ABI %s with cmd size %d, want %s with %d", test.version, abi.Version, abi.cmdSize,
+				test.want, test.cmdSize)
+		}
+	}
+}
+
+func TestABIRequest(t *testing.T) {
+	tests := []struct {
+		version string
+		ioctl   Ioctl
+		want    uintptr
+		err     error
+	}{
+		{"0.8", ZFS_IOC_POOL_STATS, 0x5a05, nil},
+		{"2.3", ZFS_IOC_POOL_STATS, 0x5a05, nil},
+		{"2.3", ZFS_IOC_POOL_SYNC, 0x5a47, nil},
+		{"0.8", ZFS_IOC_EVENTS_NEXT, 0x5a81, nil},
+		{"2.0", ZFS_IOC_GET_BOOTENV, 0x5a88, nil},
+		{"2.3", ZFS_IOC_PLATFORM, 0, unix.ENOTSUP},
+		{"2.3", ZFS_IOC_LAST, 0, unix.ENOTSUP},
+	}
+	for _, test := range tests {
+		abi, err := ABIForVersion(test.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := abi.request(test.ioctl)
+		if got != test.want || !errors.Is(err, test.err) {
+			t.Errorf("%s %v: got %#x %v, want %#x %v", test.version, test.ioctl, got, err, test.want, test.err)
+		}
+	}
+}
+
+func TestIoctlString(t *testing.T) {
+	for ioctl, want := range map[Ioctl]string{
+		ZFS_IOC_POOL_CREATE: "ZFS_IOC_POOL_CREATE",
+		ZFS_IOC_POOL_STATS:  "ZFS_IOC_POOL_STATS",
+		ZFS_IOC_POOL_SYNC:   "ZFS_IOC_POOL_SYNC",
+		ZFS_IOC_EVENTS_NEXT: "ZFS_IOC_EVENTS_NEXT",
+		ZFS_IOC_GET_BOOTENV: "ZFS_IOC_GET_BOOTENV",
+		ZFS_IOC_LAST:        "Ioctl(0x5a89)",
+	} {
+		if got := ioctl.String(); got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package ioctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/nvlist"
+	"golang.org/x/sys/unix"
+)
+
+//go:generate go run ../nvlist/nvlistgen -type PoolConfig,PoolStats,Vdev,VdevStatsEx,PoolProps,PoolPropUint64,PoolPropString,DatasetProps -output nvlist_decoders.go
+
+// defaultRespSize is the initial size of response buffers. Handles grow the buffer if a response does not fit.
+const defaultRespSize = 256 * 1024
+
+// Client provides typed wrappers around the ioctls used to read pools and datasets. It takes care of filling
+// the Cmd, allocating response buffers and passing on the cookies of the list ioctls. All ioctls are issued
+// with IoctlContext, so they give up once the context is done or they took longer than Timeout.
+type Client struct {
+	Handle Handle
+	// Timeout is the time after which a single ioctl is given up, zero disables the timeout. It applies to
+	// each ioctl on its own, e.g. listing many datasets may take longer in total.
+	Timeout time.Duration
+}
+
+// ioctl issues an ioctl with IoctlContext, giving up after the timeout of the client.
+func (c *Client) ioctl(ctx context.Context, ioctl Ioctl, cmd *Cmd, resp *[]byte) error {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	return IoctlContext(ctx, c.Handle, ioctl, cmd, nil, nil, resp)
+}
+
+// ModuleVersion returns the version of the kernel module the ioctls are issued to, or an empty string if it
+// is not known.
+func (c *Client) ModuleVersion() string {
+	return moduleVersion(c.Handle)
+}
+
+// PoolConfig is the config of an imported pool as returned by ZFS_IOC_POOL_CONFIGS.
+type PoolConfig struct {
+	Name     string `nvlist:"name"`
+	GUID     uint64 `nvlist:"pool_guid"`
+	State    uint64 `nvlist:"state"`
+	Txg      uint64 `nvlist:"txg"`
+	Version  uint64 `nvlist:"version"`
+	Hostname string `nvlist:"hostname"`
+}
+
+// PoolStats is the status of a pool as returned by ZFS_IOC_POOL_STATS.
+type PoolStats struct {
+	Name       string `nvlist:"name"`
+	State      uint64 `nvlist:"state"`
+	ErrorCount uint64 `nvlist:"error_count"`
+	VdevTree   *Vdev  `nvlist:"vdev_tree"`
+}
+
+// Vdev is a node of the vdev tree of a pool.
+//
+// The vdev class could be derived to add it as a label upon export:
+// https://sourcegraph.com/github.com/openzfs/zfs@3862ebbf1fe1f8755f9956a8eaecaefc428c8f31/-/blob/cmd/zpool/zpool_main.c?L1208-1247
+type Vdev struct {
+	Type     string       `nvlist:"type"`
+	ID       uint64       `nvlist:"id"`
+	GUID     uint64       `nvlist:"guid"`
+	Path     string       `nvlist:"path"`
+	Stats    []uint64     `nvlist:"vdev_stats"`
+	StatsEx  *VdevStatsEx `nvlist:"vdev_stats_ex"`
+	Children []*Vdev      `nvlist:"children"`
+	L2Cache  []*Vdev      `nvlist:"l2cache"`
+	Spares   []*Vdev      `nvlist:"spares"`
+
+	// ScanStats is only set on the root vdev, see ParseScanStats.
+	ScanStats []uint64 `nvlist:"scan_stats"`
+}
+
+// AllChildren returns the regular children of the vdev followed by its cache and spare devices.
+func (v *Vdev) AllChildren() []*Vdev {
+	return slices.Concat(v.Children, v.L2Cache, v.Spares)
+}
+
+// PoolProps holds the properties of a pool as returned by ZFS_IOC_POOL_GET_PROPS. Properties the pool does
+// not have, e.g. because the kernel module is too old, are left zero.
+type PoolProps struct {
+	Size          PoolPropUint64 `nvlist:"size"`
+	Allocated     PoolPropUint64 `nvlist:"allocated"`
+	Free          PoolPropUint64 `nvlist:"free"`
+	Capacity      PoolPropUint64 `nvlist:"capacity"`
+	Fragmentation PoolPropUint64 `nvlist:"fragmentation"`
+	ExpandSize    PoolPropUint64 `nvlist:"expandsize"`
+	Freeing       PoolPropUint64 `nvlist:"freeing"`
+	Leaked        PoolPropUint64 `nvlist:"leaked"`
+	DedupRatio    PoolPropUint64 `nvlist:"dedupratio"`
+	BcloneUsed    PoolPropUint64 `nvlist:"bcloneused"`
+	BcloneSaved   PoolPropUint64 `nvlist:"bclonesaved"`
+	Checkpoint    PoolPropUint64 `nvlist:"checkpoint"`
+	ReadOnly      PoolPropUint64 `nvlist:"readonly"`
+
+	AltRoot    PoolPropString `nvlist:"altroot"`
+	Comment    PoolPropString `nvlist:"comment"`
+	FailMode   PoolPropUint64 `nvlist:"failmode"`
+	Autotrim   PoolPropUint64 `nvlist:"autotrim"`
+	Autoexpand PoolPropUint64 `nvlist:"autoexpand"`
+}
+
+// PoolPropUint64 is a numeric or index pool property. Source is one of the ZPROP_SRC_* flags, it is zero if
+// the property is not set.
+type PoolPropUint64 struct {
+	Value  uint64 `nvlist:"value"`
+	Source uint64 `nvlist:"source"`
+}
+
+// PoolPropString is a string pool property. Source is one of the ZPROP_SRC_* flags, it is zero if the
+// property is not set.
+type PoolPropString struct {
+	Value  string `nvlist:"value"`
+	Source uint64 `nvlist:"source"`
+}
+
+// DatasetProps holds the numeric properties of a dataset. In the nvlist every property is an nvlist with its
+// value and source.
+type DatasetProps struct {
+	ObjsetID uint64 `nvlist:"objsetid.value"`
+
+	Available            uint64 `nvlist:"available.value"`
+	CompressRatio        uint64 `nvlist:"compressratio.value"`
+	Used                 uint64 `nvlist:"used.value"`
+	UsedByChildren       uint64 `nvlist:"usedbychildren.value"`
+	UsedByDataset        uint64 `nvlist:"usedbydataset.value"`
+	UsedByRefReservation uint64 `nvlist:"usedbyrefreservation.value"`
+	UsedBySnapshots      uint64 `nvlist:"usedbysnapshots.value"`
+	Referenced           uint64 `nvlist:"referenced.value"`
+	RefCompressRatio     uint64 `nvlist:"refcompressratio.value"`
+	LogicalReferenced    uint64 `nvlist:"logicalreferenced.value"`
+	LogicalUsed          uint64 `nvlist:"logicalused.value"`
+}
+
+// Dataset is a filesystem, volume or snapshot as returned by the list ioctls.
+type Dataset struct {
+	Name  string
+	Props DatasetProps
+}
+
+// PoolConfigs returns the configs of all imported pools.
+func (c *Client) PoolConfigs(ctx context.Context) ([]PoolConfig, error) {
+	cmd := Cmd{}
+	resp := make([]byte, defaultRespSize)
+	err := c.ioctl(ctx, ZFS_IOC_POOL_CONFIGS, &cmd, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var configs []PoolConfig
+	r := nvlist.NVListReader{Data: resp}
+	for {
+		token, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if token != nvlist.TypeNvlist {
+			return nil, fmt.Errorf("invalid pool configs")
+		}
+
+		config := PoolConfig{Name: strings.Clone(r.Name())}
+		if err := config.DecodeNvlist(&r); err != nil {
+			return nil, fmt.Errorf("error parsing config of pool %q: %w", config.Name, err)
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// PoolStats returns the status and vdev tree of the pool name.
+func (c *Client) PoolStats(ctx context.Context, name string) (*PoolStats, error) {
+	cmd := Cmd{}
+	if err := cmd.SetName(name); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, defaultRespSize)
+	err := c.ioctl(ctx, ZFS_IOC_POOL_STATS, &cmd, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &PoolStats{}
+	r := nvlist.NVListReader{Data: resp}
+	if err := stats.DecodeNvlist(&r); err != nil {
+		return nil, fmt.Errorf("error parsing stats of pool %q: %w", name, err)
+	}
+	return stats, nil
+}
+
+// PoolProps returns the properties of the pool name.
+func (c *Client) PoolProps(ctx context.Context, name string) (*PoolProps, error) {
+	cmd := Cmd{}
+	if err := cmd.SetName(name); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, defaultRespSize)
+	err := c.ioctl(ctx, ZFS_IOC_POOL_GET_PROPS, &cmd, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	props := &PoolProps{}
+	r := nvlist.NVListReader{Data: resp}
+	if err := props.DecodeNvlist(&r); err != nil {
+		return nil, fmt.Errorf("error parsing properties of pool %q: %w", name, err)
+	}
+	return props, nil
+}
+
+// DatasetIterator iterates over the direct children of the dataset parent. Iteration stops after the first
+// error.
+func (c *Client) DatasetIterator(ctx context.Context, parent string) iter.Seq2[*Dataset, error] {
+	return c.list(ctx, ZFS_IOC_DATASET_LIST_NEXT, parent)
+}
+
+// SnapshotIterator iterates over the snapshots of dataset. Iteration stops after the first error.
+func (c *Client) SnapshotIterator(ctx context.Context, dataset string) iter.Seq2[*Dataset, error] {
+	return c.list(ctx, ZFS_IOC_SNAPSHOT_LIST_NEXT, dataset)
+}
+
+// list iterates over the datasets returned by one of the list ioctls. These return one dataset per call and
+// a cookie to pass to the next call, until they fail with ESRCH.
+func (c *Client) list(ctx context.Context, ioctl Ioctl, name string) iter.Seq2[*Dataset, error] {
+	return func(yield func(*Dataset, error) bool) {
+		cmd := Cmd{}
+		resp := make([]byte, defaultRespSize)
+		cookie := uint64(0)
+		for {
+			cmd.Clear()
+			if err := cmd.SetName(name); err != nil {
+				yield(nil, err)
+				return
+			}
+			cmd.Cookie = cookie
+			err := c.ioctl(ctx, ioctl, &cmd, &resp)
+			if errors.Is(err, unix.ESRCH) {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			cookie = cmd.Cookie
+
+			dataset := &Dataset{Name: cmd.GetName()}
+			r := nvlist.NVListReader{Data: resp}
+			if err := dataset.Props.DecodeNvlist(&r); err != nil {
+				yield(nil, fmt.Errorf("error parsing properties of %q: %w", dataset.Name, err))
+				return
+			}
+			if !yield(dataset, nil) {
+				return
+			}
+		}
+	}
+}
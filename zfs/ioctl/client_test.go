@@ -0,0 +1,137 @@
+package ioctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/nvlist"
+	"golang.org/x/sys/unix"
+)
+
+// listHandle answers list ioctls with the datasets in names, using the index of the next dataset as cookie.
+// After the last dataset it fails with err, or ESRCH if err is nil.
+type listHandle struct {
+	ioctl Ioctl
+	names []string
+	props []byte
+	err   error
+	calls int
+}
+
+func (h *listHandle) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	h.calls++
+	if ioctl != h.ioctl || cmd.GetName() != "tank" {
+		return unix.EINVAL
+	}
+	if cmd.Cookie >= uint64(len(h.names)) {
+		if h.err != nil {
+			return h.err
+		}
+		return unix.ESRCH
+	}
+	cmd.SetName(h.names[cmd.Cookie])
+	cmd.Cookie++
+	copy(*resp, h.props)
+	return nil
+}
+
+func TestSnapshotIterator(t *testing.T) {
+	h := &listHandle{
+		ioctl: ZFS_IOC_SNAPSHOT_LIST_NEXT,
+		names: []string{"tank@a", "tank@b", "tank@c"},
+		props: datasetPropsFixture(t),
+	}
+	c := Client{Handle: h}
+
+	var names []string
+	for snapshot, err := range c.SnapshotIterator(context.Background(), "tank") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.Props.Used != 1003 {
+			t.Errorf("got used %d for %s, want 1003", snapshot.Props.Used, snapshot.Name)
+		}
+		names = append(names, snapshot.Name)
+	}
+	if fmt.Sprint(names) != "[tank@a tank@b tank@c]" {
+		t.Errorf("got snapshots %v", names)
+	}
+
+	// Breaking out of the loop must not issue further ioctls.
+	h.calls = 0
+	for range c.SnapshotIterator(context.Background(), "tank") {
+		break
+	}
+	if h.calls != 1 {
+		t.Errorf("got %d ioctls after break, want 1", h.calls)
+	}
+}
+
+func TestDatasetIteratorError(t *testing.T) {
+	c := Client{Handle: &listHandle{
+		ioctl: ZFS_IOC_DATASET_LIST_NEXT,
+		names: []string{"tank/a"},
+		props: datasetPropsFixture(t),
+		err:   unix.EIO,
+	}}
+
+	var names []string
+	var errs []error
+	for dataset, err := range c.DatasetIterator(context.Background(), "tank") {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		names = append(names, dataset.Name)
+	}
+	if len(names) != 1 || names[0] != "tank/a" {
+		t.Errorf("got datasets %v, want [tank/a]", names)
+	}
+	if len(errs) != 1 || !errors.Is(errs[0], unix.EIO) {
+		t.Errorf("got errors %v, want a single EIO", errs)
+	}
+}
+
+// configsHandle answers ZFS_IOC_POOL_CONFIGS with resp.
+type configsHandle []byte
+
+func (h configsHandle) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	if ioctl != ZFS_IOC_POOL_CONFIGS {
+		return unix.EINVAL
+	}
+	copy(*resp, h)
+	return nil
+}
+
+func TestPoolConfigs(t *testing.T) {
+	w := nvlist.NVListWriter{}
+	for i, name := range []string{"tank", "backup"} {
+		w.BeginNvlist(name)
+		w.AddString("name", name)
+		w.AddUInt64("pool_guid", uint64(1000+i))
+		w.AddUInt64("txg", 42)
+		w.BeginNvlist("vdev_tree")
+		w.AddString("type", "root")
+		if err := w.End(); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.End(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Client{Handle: configsHandle(w.Data)}
+	configs, err := c.PoolConfigs(context.Background())
+	if err != nil {
+		t.Fatalf("PoolConfigs() failed: %v", err)
+	}
+	want := []PoolConfig{{Name: "tank", GUID: 1000, Txg: 42}, {Name: "backup", GUID: 1001, Txg: 42}}
+	if fmt.Sprint(configs) != fmt.Sprint(want) {
+		t.Errorf("got configs %+v, want %+v", configs, want)
+	}
+}
@@ -0,0 +1,66 @@
+package ioctl
+
+import (
+	"context"
+	"fmt"
+)
+
+// StalledError is returned by IoctlContext if the context is done before the ioctl returns. It unwraps to the
+// error of the context.
+type StalledError struct {
+	Ioctl Ioctl
+	Name  string
+	Err   error
+	// Done is closed once the ioctl returns.
+	Done <-chan struct{}
+}
+
+func (e *StalledError) Error() string {
+	return fmt.Sprintf("%v for %q stalled: %v", e.Ioctl, e.Name, e.Err)
+}
+
+func (e *StalledError) Unwrap() error {
+	return e.Err
+}
+
+// IoctlContext issues an ioctl through h like Handle.Ioctl, but gives up once ctx is done. ioctls against
+// suspended pools can block in the kernel indefinitely, so the ioctl is issued from its own goroutine and
+// left to finish in the background if ctx is done first. IoctlContext then returns a StalledError, leaves
+// cmd unchanged and replaces *resp with a new buffer, as the ioctl still writes to its copy of cmd and the old
+// buffer. request and config must not be modified until the ioctl returned.
+func IoctlContext(ctx context.Context, h Handle, ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	if ctx.Done() == nil {
+		return h.Ioctl(ioctl, cmd, request, config, resp)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	workerCmd := new(Cmd)
+	*workerCmd = *cmd
+	var workerResp *[]byte
+	if resp != nil {
+		r := *resp
+		workerResp = &r
+	}
+	done := make(chan struct{})
+	var err error
+	go func() {
+		defer close(done)
+		err = h.Ioctl(ioctl, workerCmd, request, config, workerResp)
+	}()
+
+	select {
+	case <-done:
+		*cmd = *workerCmd
+		if resp != nil {
+			*resp = *workerResp
+		}
+		return err
+	case <-ctx.Done():
+		if resp != nil && *resp != nil {
+			*resp = make([]byte, len(*resp))
+		}
+		return &StalledError{Ioctl: ioctl, Name: cmd.GetName(), Err: ctx.Err(), Done: done}
+	}
+}
@@ -0,0 +1,58 @@
+package ioctl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// blockingHandle blocks ioctls until release is closed, then answers them with cookie 42 and response "ok".
+type blockingHandle struct {
+	release chan struct{}
+}
+
+func (h blockingHandle) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	<-h.release
+	cmd.Cookie = 42
+	copy(*resp, "ok")
+	return nil
+}
+
+func TestIoctlContext(t *testing.T) {
+	h := blockingHandle{release: make(chan struct{})}
+	cmd := Cmd{}
+	cmd.SetName("tank")
+	resp := make([]byte, 2)
+	oldResp := resp
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := IoctlContext(ctx, h, ZFS_IOC_POOL_STATS, &cmd, nil, nil, &resp)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want DeadlineExceeded", err)
+	}
+	var stalled *StalledError
+	if !errors.As(err, &stalled) || stalled.Name != "tank" || stalled.Ioctl != ZFS_IOC_POOL_STATS {
+		t.Fatalf("got %#v, want a StalledError for tank", err)
+	}
+	if &resp[0] == &oldResp[0] {
+		t.Error("response buffer still used by the stalled ioctl was not replaced")
+	}
+
+	close(h.release)
+	<-stalled.Done
+	if cmd.Cookie != 0 || string(resp) != "\x00\x00" {
+		t.Errorf("stalled ioctl modified cmd or response: cookie %d, response %q", cmd.Cookie, resp)
+	}
+
+	// An ioctl that returns in time updates cmd and the response.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := IoctlContext(ctx, h, ZFS_IOC_POOL_STATS, &cmd, nil, nil, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if cmd.Cookie != 42 || string(resp) != "ok" {
+		t.Errorf("got cookie %d, response %q, want 42, ok", cmd.Cookie, resp)
+	}
+}
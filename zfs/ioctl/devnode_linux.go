@@ -0,0 +1,15 @@
+package ioctl
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// CreateDeviceNode creates the ZFS device node at path, for systems where it is not created by udev.
+func CreateDeviceNode(path string) error {
+	if err := unix.Mknod(path, unix.S_IFCHR|0o666, int(unix.Mkdev(10, 54))); err != nil {
+		return fmt.Errorf("Failed to create ZFS device node: %w", err)
+	}
+	return nil
+}
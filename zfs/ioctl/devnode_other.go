@@ -0,0 +1,14 @@
+//go:build !linux
+
+package ioctl
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CreateDeviceNode fails with errors.ErrUnsupported, outside of Linux the device node is created by the
+// kernel module itself, e.g. in devfs on FreeBSD.
+func CreateDeviceNode(path string) error {
+	return fmt.Errorf("Failed to create ZFS device node: %w", errors.ErrUnsupported)
+}
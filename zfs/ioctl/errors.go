@@ -0,0 +1,110 @@
+package ioctl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Errors ZFSError matches with errors.Is, depending on the ioctl and errno.
+var (
+	// ErrPoolNotFound is returned for pools that do not exist, e.g. because they were exported or destroyed.
+	ErrPoolNotFound = errors.New("pool not found")
+	// ErrDatasetNotFound is returned for datasets and snapshots that do not exist, e.g. because they were
+	// destroyed.
+	ErrDatasetNotFound = errors.New("dataset not found")
+	// ErrPermission is returned if the caller lacks the privileges for the ioctl.
+	ErrPermission = errors.New("permission denied")
+	// ErrPoolSuspended is returned for pools whose I/O is suspended after failures.
+	ErrPoolSuspended = errors.New("pool I/O is suspended")
+)
+
+// ErrUnavailable is returned by ReopeningHandle while the ZFS device node can not be opened, e.g. because the
+// kernel module is not loaded.
+var ErrUnavailable = errors.New("zfs is unavailable")
+
+// ErrForbiddenIoctl is returned by ReadOnlyHandle for ioctls that could modify pools or datasets.
+var ErrForbiddenIoctl = errors.New("ioctl is forbidden in read-only mode")
+
+// zfsErrFirst is the first of the ZFS specific errnos in zfs_errno_t, see sys/fs/zfs.h.
+const zfsErrFirst unix.Errno = 1024
+
+// zfsErrNames are the names of zfs_errno_t, starting at zfsErrFirst.
+var zfsErrNames = [...]string{
+	"ZFS_ERR_CHECKPOINT_EXISTS",
+	"ZFS_ERR_DISCARDING_CHECKPOINT",
+	"ZFS_ERR_NO_CHECKPOINT",
+	"ZFS_ERR_DEVRM_IN_PROGRESS",
+	"ZFS_ERR_VDEV_TOO_BIG",
+	"ZFS_ERR_IOC_CMD_UNAVAIL",
+	"ZFS_ERR_IOC_ARG_UNAVAIL",
+	"ZFS_ERR_IOC_ARG_REQUIRED",
+	"ZFS_ERR_IOC_ARG_BADTYPE",
+	"ZFS_ERR_WRONG_PARENT",
+	"ZFS_ERR_FROM_IVSET_GUID_MISSING",
+	"ZFS_ERR_FROM_IVSET_GUID_MISMATCH",
+	"ZFS_ERR_SPILL_BLOCK_FLAG_MISSING",
+	"ZFS_ERR_UNKNOWN_SEND_STREAM_FEATURE",
+	"ZFS_ERR_EXPORT_IN_PROGRESS",
+	"ZFS_ERR_BOOKMARK_SOURCE_NOT_ANCESTOR",
+	"ZFS_ERR_STREAM_TRUNCATED",
+	"ZFS_ERR_STREAM_LARGE_BLOCK_MISMATCH",
+	"ZFS_ERR_RESILVER_IN_PROGRESS",
+	"ZFS_ERR_REBUILD_IN_PROGRESS",
+	"ZFS_ERR_BADPROP",
+	"ZFS_ERR_VDEV_NOTSUP",
+}
+
+// ZFSError is an errno returned by the kernel for an ioctl, together with the ioctl and the name of the
+// pool, dataset or snapshot it was issued for. It unwraps to Errno, and matches the ErrPoolNotFound,
+// ErrDatasetNotFound, ErrPermission and ErrPoolSuspended sentinels.
+type ZFSError struct {
+	Ioctl Ioctl
+	Name  string
+	Errno unix.Errno
+}
+
+func (e *ZFSError) Error() string {
+	return fmt.Sprintf("%v for %q failed: %s", e.Ioctl, e.Name, errnoString(e.Errno))
+}
+
+func (e *ZFSError) Unwrap() error {
+	return e.Errno
+}
+
+func (e *ZFSError) Is(target error) bool {
+	switch target {
+	case ErrPoolNotFound:
+		// The root dataset of a pool is named like the pool.
+		return e.Errno == unix.ENOENT && (e.isPoolIoctl() || !strings.ContainsAny(e.Name, "/@#"))
+	case ErrDatasetNotFound:
+		return e.Errno == unix.ENOENT && !e.isPoolIoctl()
+	case ErrPermission:
+		return e.Errno == unix.EPERM || e.Errno == unix.EACCES
+	case ErrPoolSuspended:
+		// libzfs reports EAGAIN as "pool I/O is currently suspended".
+		return e.Errno == unix.EAGAIN
+	}
+	return false
+}
+
+// isPoolIoctl returns whether the ioctl is issued for a pool or one of its vdevs rather than a dataset.
+func (e *ZFSError) isPoolIoctl() bool {
+	name := e.Ioctl.String()
+	return strings.HasPrefix(name, "ZFS_IOC_POOL_") || strings.HasPrefix(name, "ZFS_IOC_VDEV_")
+}
+
+// errnoString returns the message of errno, using the names of the ZFS specific errnos.
+func errnoString(errno unix.Errno) string {
+	switch {
+	case errno == ECKSUM:
+		return "checksum mismatch"
+	case errno >= zfsErrFirst && int(errno-zfsErrFirst) < len(zfsErrNames):
+		return zfsErrNames[errno-zfsErrFirst]
+	case errno >= zfsErrFirst:
+		return fmt.Sprintf("ZFS error %d", int(errno))
+	}
+	return errno.Error()
+}
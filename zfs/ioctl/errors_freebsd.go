@@ -0,0 +1,6 @@
+package ioctl
+
+import "golang.org/x/sys/unix"
+
+// ECKSUM is returned for blocks that fail their checksum, see sys/zfs_context.h.
+const ECKSUM = unix.EINTEGRITY
@@ -0,0 +1,9 @@
+//go:build !linux && !freebsd
+
+package ioctl
+
+import "golang.org/x/sys/unix"
+
+// ECKSUM is returned for blocks that fail their checksum. Only Linux and FreeBSD have an errno for it, on
+// other systems ECKSUM is an errno that is never returned.
+const ECKSUM = ^unix.Errno(0)
@@ -0,0 +1,69 @@
+package ioctl
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestZFSErrorIs(t *testing.T) {
+	tests := []struct {
+		err    *ZFSError
+		target error
+		want   bool
+	}{
+		{&ZFSError{ZFS_IOC_POOL_STATS, "tank", unix.ENOENT}, ErrPoolNotFound, true},
+		{&ZFSError{ZFS_IOC_POOL_STATS, "tank", unix.ENOENT}, ErrDatasetNotFound, false},
+		{&ZFSError{ZFS_IOC_DATASET_LIST_NEXT, "tank/a", unix.ENOENT}, ErrDatasetNotFound, true},
+		{&ZFSError{ZFS_IOC_DATASET_LIST_NEXT, "tank/a", unix.ENOENT}, ErrPoolNotFound, false},
+		// The root dataset vanishes with its pool.
+		{&ZFSError{ZFS_IOC_DATASET_LIST_NEXT, "tank", unix.ENOENT}, ErrPoolNotFound, true},
+		{&ZFSError{ZFS_IOC_SNAPSHOT_LIST_NEXT, "tank@a", unix.ENOENT}, ErrDatasetNotFound, true},
+		{&ZFSError{ZFS_IOC_POOL_GET_PROPS, "tank", unix.EACCES}, ErrPermission, true},
+		{&ZFSError{ZFS_IOC_POOL_GET_PROPS, "tank", unix.EPERM}, ErrPermission, true},
+		{&ZFSError{ZFS_IOC_POOL_STATS, "tank", unix.EAGAIN}, ErrPoolSuspended, true},
+		{&ZFSError{ZFS_IOC_POOL_STATS, "tank", unix.EIO}, ErrPoolSuspended, false},
+		{&ZFSError{ZFS_IOC_POOL_STATS, "tank", unix.EIO}, unix.EIO, true},
+	}
+	for _, test := range tests {
+		if got := errors.Is(test.err, test.target); got != test.want {
+			t.Errorf("errors.Is(%v, %v) = %v, want %v", test.err, test.target, got, test.want)
+		}
+	}
+}
+
+func TestZFSErrorString(t *testing.T) {
+	tests := []struct {
+		err  *ZFSError
+		want string
+	}{
+		{&ZFSError{ZFS_IOC_POOL_STATS, "tank", unix.ENOENT}, `ZFS_IOC_POOL_STATS for "tank" failed: no such file or directory`},
+		{&ZFSError{ZFS_IOC_OBJSET_STATS, "tank/a", ECKSUM}, `ZFS_IOC_OBJSET_STATS for "tank/a" failed: checksum mismatch`},
+		{&ZFSError{ZFS_IOC_POOL_SCAN, "tank", zfsErrFirst + 18}, `ZFS_IOC_POOL_SCAN for "tank" failed: ZFS_ERR_RESILVER_IN_PROGRESS`},
+		{&ZFSError{ZFS_IOC_POOL_SCAN, "tank", zfsErrFirst + 1000}, `ZFS_IOC_POOL_SCAN for "tank" failed: ZFS error 2024`},
+	}
+	for _, test := range tests {
+		if got := test.err.Error(); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
+
+func TestIoctlReturnsZFSError(t *testing.T) {
+	cmd := Cmd{}
+	cmd.SetName("gone")
+	resp := make([]byte, 16)
+	err := (&ReplayHandle{Dir: t.TempDir()}).Ioctl(ZFS_IOC_POOL_STATS, &cmd, nil, nil, &resp)
+
+	var zfsErr *ZFSError
+	if !errors.As(err, &zfsErr) {
+		t.Fatalf("got %#v, want a ZFSError", err)
+	}
+	if zfsErr.Ioctl != ZFS_IOC_POOL_STATS || zfsErr.Name != "gone" || zfsErr.Errno != unix.ENOENT {
+		t.Errorf("got %+v", zfsErr)
+	}
+	if !errors.Is(err, ErrPoolNotFound) {
+		t.Errorf("%v does not match ErrPoolNotFound", err)
+	}
+}
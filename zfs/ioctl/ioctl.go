@@ -14,63 +14,159 @@ import (
 
 type ZFSHandle struct {
 	zfsHandle *os.File
+	abi       *ABI
 }
 
+// DetectABI returns the ABI of the loaded kernel module. It fails if the version is not supported.
+func DetectABI() (*ABI, error) {
+	version, err := ModuleVersion()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read ZFS module version: %w", err)
+	}
+	return ABIForVersion(version)
+}
+
+// NewZFSHandleWithPath opens the ZFS device node at path. It picks the ABI from the version of the loaded
+// kernel module and fails if the version is not supported.
 func NewZFSHandleWithPath(path string) (*ZFSHandle, error) {
-	zfsHandle, err := os.Open(path)
-	if os.IsNotExist(err) {
-		unix.Mknod(path, 666, int(unix.Mkdev(10, 54)))
+	abi, err := DetectABI()
+	if err != nil {
+		return nil, err
 	}
-	zfsHandle, err = os.Open(path)
+	return NewZFSHandleWithABI(path, abi)
+}
+
+// NewZFSHandleWithABI opens the ZFS device node at path and issues all ioctls with abi. The device node is
+// not created if it is missing, see CreateDeviceNode.
+func NewZFSHandleWithABI(path string, abi *ABI) (*ZFSHandle, error) {
+	zfsHandle, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to open or create ZFS device node: %v", err)
+		return nil, fmt.Errorf("Failed to open ZFS device node: %w", err)
 	}
+	return NewZFSHandleWithFile(zfsHandle, abi), nil
+}
+
+// NewZFSHandleWithFile issues all ioctls with abi on the already opened ZFS device node f, e.g. one passed
+// in by the service manager.
+func NewZFSHandleWithFile(f *os.File, abi *ABI) *ZFSHandle {
 	return &ZFSHandle{
-		zfsHandle: zfsHandle,
-	}, nil
+		zfsHandle: f,
+		abi:       abi,
+	}
 }
 
 func NewZFSHandle() (*ZFSHandle, error) {
 	return NewZFSHandleWithPath("/dev/zfs")
 }
 
-// ZfsIoctl issues a low-level ioctl syscall with only some common wrappers. All unsafety is contained in here.
-func (h *ZFSHandle) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+// Handle issues ZFS ioctls. ZFSHandle implements it on top of the kernel, Recorder and ReplayHandle allow
+// to record ioctls and replay them without ZFS.
+type Handle interface {
+	// Ioctl issues ioctl with cmd, passing request and config as source and config nvlists. If resp is not nil,
+	// the response nvlist is written to it. resp is grown if it is too small to hold the response.
+	Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error
+}
+
+// ModuleVersioner is implemented by handles that know the version of the kernel module their ioctls are
+// issued to.
+type ModuleVersioner interface {
+	// ModuleVersion returns the version of the kernel module, e.g. 2.2, or an empty string if it is not known.
+	ModuleVersion() string
+}
+
+// moduleVersion returns the version of the kernel module h issues ioctls to, or an empty string if h does not
+// know it.
+func moduleVersion(h Handle) string {
+	if v, ok := h.(ModuleVersioner); ok {
+		return v.ModuleVersion()
+	}
+	return ""
+}
+
+// ioctler issues a single attempt of an ioctl. It returns ENOMEM and sets cmd.Nvlist_dst_size to the required
+// size if resp is too small for the response.
+type ioctler interface {
+	ioctlOnce(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp []byte) error
+}
+
+// doIoctl issues an ioctl through h, retrying it with a large enough response buffer as long as it fails
+// with ENOMEM. Errnos are returned as ZFSError.
+func doIoctl(h ioctler, ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	name := cmd.GetName()
 	for {
-		// WARNING: Here be dragons! This is completely outside of Go's safety net and uses various
-		// criticial runtime workarounds to make sure that memory is safely handled
-		if resp != nil && *resp != nil {
-			cmd.Nvlist_dst = uint64(uintptr(unsafe.Pointer(&(*resp)[0])))
-			cmd.Nvlist_dst_size = uint64(len(*resp))
-		}
-		if request != nil {
-			cmd.Nvlist_src = uint64(uintptr(unsafe.Pointer(&request[0])))
-			cmd.Nvlist_src_size = uint64(len(request))
+		var respBuf []byte
+		if resp != nil {
+			respBuf = *resp
 		}
-		if config != nil {
-			cmd.Nvlist_conf = uint64(uintptr(unsafe.Pointer(&config[0])))
-			cmd.Nvlist_conf_size = uint64(len(config))
-		}
-		_, _, errno := unix.Syscall(unix.SYS_IOCTL, h.zfsHandle.Fd(), uintptr(ioctl), uintptr(unsafe.Pointer(cmd)))
-		if request != nil {
-			runtime.KeepAlive(request)
-		}
-		if config != nil {
-			runtime.KeepAlive(config)
-		}
-		if resp != nil && *resp != nil {
-			runtime.KeepAlive(*resp)
-		}
-		runtime.KeepAlive(cmd)
-		if errno == unix.ENOMEM && resp != nil && *resp != nil {
+		err := h.ioctlOnce(ioctl, cmd, request, config, respBuf)
+		if err == unix.ENOMEM && resp != nil && *resp != nil {
 			requiredLength := cmd.Nvlist_dst_size
 			*resp = make([]byte, requiredLength)
 			continue
 		}
-		if errno != 0 {
-			return errno
+		if errno, ok := err.(unix.Errno); ok {
+			return &ZFSError{Ioctl: ioctl, Name: name, Errno: errno}
 		}
-		break
+		return err
+	}
+}
+
+// ModuleVersion returns the major and minor version of the kernel module the handle was opened for.
+func (h *ZFSHandle) ModuleVersion() string {
+	return h.abi.Version
+}
+
+// ZfsIoctl issues a low-level ioctl syscall with only some common wrappers. All unsafety is contained in here.
+func (h *ZFSHandle) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	return doIoctl(h, ioctl, cmd, request, config, resp)
+}
+
+func (h *ZFSHandle) ioctlOnce(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp []byte) error {
+	// WARNING: Here be dragons! This is completely outside of Go's safety net and uses various
+	// criticial runtime workarounds to make sure that memory is safely handled
+	req, err := h.abi.request(ioctl)
+	if err != nil {
+		return err
+	}
+	if resp != nil {
+		cmd.Nvlist_dst = uint64(uintptr(unsafe.Pointer(&resp[0])))
+		cmd.Nvlist_dst_size = uint64(len(resp))
+	}
+	if request != nil {
+		cmd.Nvlist_src = uint64(uintptr(unsafe.Pointer(&request[0])))
+		cmd.Nvlist_src_size = uint64(len(request))
+	}
+	if config != nil {
+		cmd.Nvlist_conf = uint64(uintptr(unsafe.Pointer(&config[0])))
+		cmd.Nvlist_conf_size = uint64(len(config))
+	}
+	// The kernel copies the zfs_cmd_t of its release. If it is larger than Cmd, the ioctl is issued with a
+	// copy that has room for the fields appended by the release.
+	kernelCmd := unsafe.Pointer(cmd)
+	var cmdV2_2Buf *cmdV2_2
+	if h.abi.cmdSize == unsafe.Sizeof(cmdV2_2{}) {
+		cmdV2_2Buf = &cmdV2_2{Cmd: *cmd}
+		kernelCmd = unsafe.Pointer(cmdV2_2Buf)
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, h.zfsHandle.Fd(), req, uintptr(kernelCmd))
+	if cmdV2_2Buf != nil {
+		*cmd = cmdV2_2Buf.Cmd
+	}
+	if request != nil {
+		runtime.KeepAlive(request)
+	}
+	if config != nil {
+		runtime.KeepAlive(config)
+	}
+	if resp != nil {
+		runtime.KeepAlive(resp)
+	}
+	runtime.KeepAlive(cmd)
+	// The file closes the descriptor once it is garbage collected.
+	runtime.KeepAlive(h.zfsHandle)
+	runtime.KeepAlive(cmdV2_2Buf)
+	if errno != 0 {
+		return errno
 	}
 	return nil
 }
@@ -79,8 +175,8 @@ func (c *Cmd) Clear() {
 	*c = *(&Cmd{})
 }
 
-func (c *Cmd) SetName(name string) {
-	stringToDelimitedBuf(name, c.Name[:])
+func (c *Cmd) SetName(name string) error {
+	return stringToDelimitedBuf(name, c.Name[:])
 }
 
 func (c *Cmd) GetName() string {
@@ -107,5 +203,6 @@ func stringToDelimitedBuf(str string, buf []byte) error {
 		}
 		buf[i] = str[i]
 	}
+	buf[len(str)] = 0x00
 	return nil
 }
@@ -0,0 +1,100 @@
+package ioctl
+
+import "fmt"
+
+var ioctlNames = [...]string{
+	"ZFS_IOC_POOL_CREATE",
+	"ZFS_IOC_POOL_DESTROY",
+	"ZFS_IOC_POOL_IMPORT",
+	"ZFS_IOC_POOL_EXPORT",
+	"ZFS_IOC_POOL_CONFIGS",
+	"ZFS_IOC_POOL_STATS",
+	"ZFS_IOC_POOL_TRYIMPORT",
+	"ZFS_IOC_POOL_SCAN",
+	"ZFS_IOC_POOL_FREEZE",
+	"ZFS_IOC_POOL_UPGRADE",
+	"ZFS_IOC_POOL_GET_HISTORY",
+	"ZFS_IOC_VDEV_ADD",
+	"ZFS_IOC_VDEV_REMOVE",
+	"ZFS_IOC_VDEV_SET_STATE",
+	"ZFS_IOC_VDEV_ATTACH",
+	"ZFS_IOC_VDEV_DETACH",
+	"ZFS_IOC_VDEV_SETPATH",
+	"ZFS_IOC_VDEV_SETFRU",
+	"ZFS_IOC_OBJSET_STATS",
+	"ZFS_IOC_OBJSET_ZPLPROPS",
+	"ZFS_IOC_DATASET_LIST_NEXT",
+	"ZFS_IOC_SNAPSHOT_LIST_NEXT",
+	"ZFS_IOC_SET_PROP",
+	"ZFS_IOC_CREATE",
+	"ZFS_IOC_DESTROY",
+	"ZFS_IOC_ROLLBACK",
+	"ZFS_IOC_RENAME",
+	"ZFS_IOC_RECV",
+	"ZFS_IOC_SEND",
+	"ZFS_IOC_INJECT_FAULT",
+	"ZFS_IOC_CLEAR_FAULT",
+	"ZFS_IOC_INJECT_LIST_NEXT",
+	"ZFS_IOC_ERROR_LOG",
+	"ZFS_IOC_CLEAR",
+	"ZFS_IOC_PROMOTE",
+	"ZFS_IOC_SNAPSHOT",
+	"ZFS_IOC_DSOBJ_TO_DSNAME",
+	"ZFS_IOC_OBJ_TO_PATH",
+	"ZFS_IOC_POOL_SET_PROPS",
+	"ZFS_IOC_POOL_GET_PROPS",
+	"ZFS_IOC_SET_FSACL",
+	"ZFS_IOC_GET_FSACL",
+	"ZFS_IOC_SHARE",
+	"ZFS_IOC_INHERIT_PROP",
+	"ZFS_IOC_SMB_ACL",
+	"ZFS_IOC_USERSPACE_ONE",
+	"ZFS_IOC_USERSPACE_MANY",
+	"ZFS_IOC_USERSPACE_UPGRADE",
+	"ZFS_IOC_HOLD",
+	"ZFS_IOC_RELEASE",
+	"ZFS_IOC_GET_HOLDS",
+	"ZFS_IOC_OBJSET_RECVD_PROPS",
+	"ZFS_IOC_VDEV_SPLIT",
+	"ZFS_IOC_NEXT_OBJ",
+	"ZFS_IOC_DIFF",
+	"ZFS_IOC_TMP_SNAPSHOT",
+	"ZFS_IOC_OBJ_TO_STATS",
+	"ZFS_IOC_SPACE_WRITTEN",
+	"ZFS_IOC_SPACE_SNAPS",
+	"ZFS_IOC_DESTROY_SNAPS",
+	"ZFS_IOC_POOL_REGUID",
+	"ZFS_IOC_POOL_REOPEN",
+	"ZFS_IOC_SEND_PROGRESS",
+	"ZFS_IOC_LOG_HISTORY",
+	"ZFS_IOC_SEND_NEW",
+	"ZFS_IOC_SEND_SPACE",
+	"ZFS_IOC_CLONE",
+	"ZFS_IOC_BOOKMARK",
+	"ZFS_IOC_GET_BOOKMARKS",
+	"ZFS_IOC_DESTROY_BOOKMARKS",
+	"ZFS_IOC_RECV_NEW",
+	"ZFS_IOC_POOL_SYNC",
+}
+
+var platformIoctlNames = [...]string{
+	"ZFS_IOC_PLATFORM",
+	"ZFS_IOC_EVENTS_NEXT",
+	"ZFS_IOC_EVENTS_CLEAR",
+	"ZFS_IOC_EVENTS_SEEK",
+	"ZFS_IOC_NEXTBOOT",
+	"ZFS_IOC_JAIL",
+	"ZFS_IOC_UNJAIL",
+	"ZFS_IOC_SET_BOOTENV",
+	"ZFS_IOC_GET_BOOTENV",
+}
+
+func (i Ioctl) String() string {
+	if i >= ZFS_IOC_FIRST && int(i-ZFS_IOC_FIRST) < len(ioctlNames) {
+		return ioctlNames[i-ZFS_IOC_FIRST]
+	}
+	if i >= ZFS_IOC_PLATFORM && int(i-ZFS_IOC_PLATFORM) < len(platformIoctlNames) {
+		return platformIoctlNames[i-ZFS_IOC_PLATFORM]
+	}
+	return fmt.Sprintf("Ioctl(%#x)", uint32(i))
+}
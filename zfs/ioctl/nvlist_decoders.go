@@ -0,0 +1,859 @@
+// Code generated by nvlistgen; DO NOT EDIT.
+
+package ioctl
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/nvlist"
+)
+
+// DecodeNvlist decodes the remaining pairs of the current nvlist into p.
+func (p *PoolConfig) DecodeNvlist(r *nvlist.NVListReader) error {
+	for {
+		token, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch r.Name() {
+		case "name":
+			if token != nvlist.TypeString {
+				return fmt.Errorf("invalid type for name")
+			}
+			val, err := r.String()
+			if err != nil {
+				return err
+			}
+			p.Name = strings.Clone(val)
+		case "pool_guid":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for pool_guid")
+			}
+			p.GUID = r.UInt64()
+		case "state":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for state")
+			}
+			p.State = r.UInt64()
+		case "txg":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for txg")
+			}
+			p.Txg = r.UInt64()
+		case "version":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for version")
+			}
+			p.Version = r.UInt64()
+		case "hostname":
+			if token != nvlist.TypeString {
+				return fmt.Errorf("invalid type for hostname")
+			}
+			val, err := r.String()
+			if err != nil {
+				return err
+			}
+			p.Hostname = strings.Clone(val)
+		default:
+			if err := r.SkipValue(token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeNvlist decodes the remaining pairs of the current nvlist into p.
+func (p *PoolStats) DecodeNvlist(r *nvlist.NVListReader) error {
+	for {
+		token, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch r.Name() {
+		case "name":
+			if token != nvlist.TypeString {
+				return fmt.Errorf("invalid type for name")
+			}
+			val, err := r.String()
+			if err != nil {
+				return err
+			}
+			p.Name = strings.Clone(val)
+		case "state":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for state")
+			}
+			p.State = r.UInt64()
+		case "error_count":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for error_count")
+			}
+			p.ErrorCount = r.UInt64()
+		case "vdev_tree":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for vdev_tree")
+			}
+			p.VdevTree = &Vdev{}
+			if err := p.VdevTree.DecodeNvlist(r); err != nil {
+				return err
+			}
+		default:
+			if err := r.SkipValue(token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeNvlist decodes the remaining pairs of the current nvlist into v.
+func (v *Vdev) DecodeNvlist(r *nvlist.NVListReader) error {
+	for {
+		token, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch r.Name() {
+		case "type":
+			if token != nvlist.TypeString {
+				return fmt.Errorf("invalid type for type")
+			}
+			val, err := r.String()
+			if err != nil {
+				return err
+			}
+			v.Type = strings.Clone(val)
+		case "id":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for id")
+			}
+			v.ID = r.UInt64()
+		case "guid":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for guid")
+			}
+			v.GUID = r.UInt64()
+		case "path":
+			if token != nvlist.TypeString {
+				return fmt.Errorf("invalid type for path")
+			}
+			val, err := r.String()
+			if err != nil {
+				return err
+			}
+			v.Path = strings.Clone(val)
+		case "vdev_stats":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_stats")
+			}
+			v.Stats = slices.Clone(r.UInt64Array())
+		case "vdev_stats_ex":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for vdev_stats_ex")
+			}
+			v.StatsEx = &VdevStatsEx{}
+			if err := v.StatsEx.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "children":
+			if token != nvlist.TypeNvlistArray {
+				return fmt.Errorf("invalid type for children")
+			}
+			v.Children = make([]*Vdev, r.NumElements())
+			for i := range v.Children {
+				v.Children[i] = &Vdev{}
+				if err := v.Children[i].DecodeNvlist(r); err != nil {
+					return err
+				}
+			}
+		case "l2cache":
+			if token != nvlist.TypeNvlistArray {
+				return fmt.Errorf("invalid type for l2cache")
+			}
+			v.L2Cache = make([]*Vdev, r.NumElements())
+			for i := range v.L2Cache {
+				v.L2Cache[i] = &Vdev{}
+				if err := v.L2Cache[i].DecodeNvlist(r); err != nil {
+					return err
+				}
+			}
+		case "spares":
+			if token != nvlist.TypeNvlistArray {
+				return fmt.Errorf("invalid type for spares")
+			}
+			v.Spares = make([]*Vdev, r.NumElements())
+			for i := range v.Spares {
+				v.Spares[i] = &Vdev{}
+				if err := v.Spares[i].DecodeNvlist(r); err != nil {
+					return err
+				}
+			}
+		case "scan_stats":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for scan_stats")
+			}
+			v.ScanStats = slices.Clone(r.UInt64Array())
+		default:
+			if err := r.SkipValue(token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeNvlist decodes the remaining pairs of the current nvlist into v.
+func (v *VdevStatsEx) DecodeNvlist(r *nvlist.NVListReader) error {
+	for {
+		token, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch r.Name() {
+		case "vdev_sync_r_active_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_sync_r_active_queue")
+			}
+			v.SyncReadActive = r.UInt64()
+		case "vdev_sync_w_active_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_sync_w_active_queue")
+			}
+			v.SyncWriteActive = r.UInt64()
+		case "vdev_async_r_active_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_async_r_active_queue")
+			}
+			v.AsyncReadActive = r.UInt64()
+		case "vdev_async_w_active_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_async_w_active_queue")
+			}
+			v.AsyncWriteActive = r.UInt64()
+		case "vdev_async_scrub_active_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_async_scrub_active_queue")
+			}
+			v.ScrubActive = r.UInt64()
+		case "vdev_async_trim_active_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_async_trim_active_queue")
+			}
+			v.TrimActive = r.UInt64()
+		case "vdev_rebuild_active_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_rebuild_active_queue")
+			}
+			v.RebuildActive = r.UInt64()
+		case "vdev_sync_r_pend_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_sync_r_pend_queue")
+			}
+			v.SyncReadPending = r.UInt64()
+		case "vdev_sync_w_pend_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_sync_w_pend_queue")
+			}
+			v.SyncWritePending = r.UInt64()
+		case "vdev_async_r_pend_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_async_r_pend_queue")
+			}
+			v.AsyncReadPending = r.UInt64()
+		case "vdev_async_w_pend_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_async_w_pend_queue")
+			}
+			v.AsyncWritePending = r.UInt64()
+		case "vdev_async_scrub_pend_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_async_scrub_pend_queue")
+			}
+			v.ScrubPending = r.UInt64()
+		case "vdev_async_trim_pend_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_async_trim_pend_queue")
+			}
+			v.TrimPending = r.UInt64()
+		case "vdev_rebuild_pend_queue":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for vdev_rebuild_pend_queue")
+			}
+			v.RebuildPending = r.UInt64()
+		case "vdev_tot_r_lat_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_tot_r_lat_histo")
+			}
+			v.TotalReadLatency = slices.Clone(r.UInt64Array())
+		case "vdev_tot_w_lat_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_tot_w_lat_histo")
+			}
+			v.TotalWriteLatency = slices.Clone(r.UInt64Array())
+		case "vdev_disk_r_lat_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_disk_r_lat_histo")
+			}
+			v.DiskReadLatency = slices.Clone(r.UInt64Array())
+		case "vdev_disk_w_lat_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_disk_w_lat_histo")
+			}
+			v.DiskWriteLatency = slices.Clone(r.UInt64Array())
+		case "vdev_sync_r_lat_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_sync_r_lat_histo")
+			}
+			v.SyncReadQueueLatency = slices.Clone(r.UInt64Array())
+		case "vdev_sync_w_lat_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_sync_w_lat_histo")
+			}
+			v.SyncWriteQueueLatency = slices.Clone(r.UInt64Array())
+		case "vdev_async_r_lat_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_async_r_lat_histo")
+			}
+			v.AsyncReadQueueLatency = slices.Clone(r.UInt64Array())
+		case "vdev_async_w_lat_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_async_w_lat_histo")
+			}
+			v.AsyncWriteQueueLatency = slices.Clone(r.UInt64Array())
+		case "vdev_scrub_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_scrub_histo")
+			}
+			v.ScrubQueueLatency = slices.Clone(r.UInt64Array())
+		case "vdev_trim_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_trim_histo")
+			}
+			v.TrimQueueLatency = slices.Clone(r.UInt64Array())
+		case "vdev_rebuild_histo":
+			if token != nvlist.TypeUint64Array {
+				return fmt.Errorf("invalid type for vdev_rebuild_histo")
+			}
+			v.RebuildQueueLatency = slices.Clone(r.UInt64Array())
+		default:
+			if err := r.SkipValue(token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeNvlist decodes the remaining pairs of the current nvlist into p.
+func (p *PoolProps) DecodeNvlist(r *nvlist.NVListReader) error {
+	for {
+		token, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch r.Name() {
+		case "size":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for size")
+			}
+			if err := p.Size.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "allocated":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for allocated")
+			}
+			if err := p.Allocated.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "free":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for free")
+			}
+			if err := p.Free.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "capacity":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for capacity")
+			}
+			if err := p.Capacity.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "fragmentation":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for fragmentation")
+			}
+			if err := p.Fragmentation.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "expandsize":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for expandsize")
+			}
+			if err := p.ExpandSize.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "freeing":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for freeing")
+			}
+			if err := p.Freeing.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "leaked":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for leaked")
+			}
+			if err := p.Leaked.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "dedupratio":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for dedupratio")
+			}
+			if err := p.DedupRatio.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "bcloneused":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for bcloneused")
+			}
+			if err := p.BcloneUsed.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "bclonesaved":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for bclonesaved")
+			}
+			if err := p.BcloneSaved.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "checkpoint":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for checkpoint")
+			}
+			if err := p.Checkpoint.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "readonly":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for readonly")
+			}
+			if err := p.ReadOnly.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "altroot":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for altroot")
+			}
+			if err := p.AltRoot.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "comment":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for comment")
+			}
+			if err := p.Comment.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "failmode":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for failmode")
+			}
+			if err := p.FailMode.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "autotrim":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for autotrim")
+			}
+			if err := p.Autotrim.DecodeNvlist(r); err != nil {
+				return err
+			}
+		case "autoexpand":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for autoexpand")
+			}
+			if err := p.Autoexpand.DecodeNvlist(r); err != nil {
+				return err
+			}
+		default:
+			if err := r.SkipValue(token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeNvlist decodes the remaining pairs of the current nvlist into p.
+func (p *PoolPropUint64) DecodeNvlist(r *nvlist.NVListReader) error {
+	for {
+		token, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch r.Name() {
+		case "value":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for value")
+			}
+			p.Value = r.UInt64()
+		case "source":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for source")
+			}
+			p.Source = r.UInt64()
+		default:
+			if err := r.SkipValue(token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeNvlist decodes the remaining pairs of the current nvlist into p.
+func (p *PoolPropString) DecodeNvlist(r *nvlist.NVListReader) error {
+	for {
+		token, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch r.Name() {
+		case "value":
+			if token != nvlist.TypeString {
+				return fmt.Errorf("invalid type for value")
+			}
+			val, err := r.String()
+			if err != nil {
+				return err
+			}
+			p.Value = strings.Clone(val)
+		case "source":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for source")
+			}
+			p.Source = r.UInt64()
+		default:
+			if err := r.SkipValue(token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeNvlist decodes the remaining pairs of the current nvlist into d.
+func (d *DatasetProps) DecodeNvlist(r *nvlist.NVListReader) error {
+	for {
+		token, err := r.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch r.Name() {
+		case "objsetid":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for objsetid")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for objsetid")
+					}
+					d.ObjsetID = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "available":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for available")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for available")
+					}
+					d.Available = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "compressratio":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for compressratio")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for compressratio")
+					}
+					d.CompressRatio = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "used":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for used")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for used")
+					}
+					d.Used = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "usedbychildren":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for usedbychildren")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for usedbychildren")
+					}
+					d.UsedByChildren = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "usedbydataset":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for usedbydataset")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for usedbydataset")
+					}
+					d.UsedByDataset = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "usedbyrefreservation":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for usedbyrefreservation")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for usedbyrefreservation")
+					}
+					d.UsedByRefReservation = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "usedbysnapshots":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for usedbysnapshots")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for usedbysnapshots")
+					}
+					d.UsedBySnapshots = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "referenced":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for referenced")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for referenced")
+					}
+					d.Referenced = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "refcompressratio":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for refcompressratio")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for refcompressratio")
+					}
+					d.RefCompressRatio = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "logicalreferenced":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for logicalreferenced")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for logicalreferenced")
+					}
+					d.LogicalReferenced = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		case "logicalused":
+			if token != nvlist.TypeNvlist {
+				return fmt.Errorf("invalid type for logicalused")
+			}
+			for {
+				token, err := r.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return err
+				}
+				switch r.Name() {
+				case "value":
+					if token != nvlist.TypeUint64 {
+						return fmt.Errorf("invalid type for logicalused")
+					}
+					d.LogicalUsed = r.UInt64()
+				default:
+					if err := r.SkipValue(token); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			if err := r.SkipValue(token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package ioctl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/nvlist"
+)
+
+// datasetPropsFixture builds the properties of a dataset as returned by ZFS_IOC_DATASET_LIST_NEXT.
+func datasetPropsFixture(t testing.TB) []byte {
+	w := nvlist.NVListWriter{}
+	props := []string{"objsetid", "available", "compressratio", "used", "usedbychildren", "usedbydataset",
+		"usedbyrefreservation", "usedbysnapshots", "referenced", "refcompressratio", "logicalreferenced",
+		"logicalused", "written", "creation", "guid"}
+	for i, prop := range props {
+		w.BeginNvlist(prop)
+		w.AddUInt64("value", uint64(1000+i))
+		w.AddString("source", "tank")
+		if err := w.End(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, prop := range []string{"mountpoint", "compression"} {
+		w.BeginNvlist(prop)
+		w.AddString("value", "/"+prop)
+		w.AddString("source", "tank")
+		if err := w.End(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	return w.Data
+}
+
+// vdevTreeFixture builds a vdev tree with two mirrors of two disks and a cache device.
+func vdevTreeFixture(t testing.TB) []byte {
+	stats := make([]uint64, 41)
+	for i := range stats {
+		stats[i] = uint64(i)
+	}
+	w := nvlist.NVListWriter{}
+	disk := func(id uint64) {
+		w.AddString("type", "disk")
+		w.AddUInt64("id", id)
+		w.AddUInt64("guid", 0x1000+id)
+		w.AddString("path", fmt.Sprintf("/dev/disk/by-id/ata-disk%d-part1", id))
+		w.AddUInt64("whole_disk", 1)
+		w.AddUInt64Array("vdev_stats", stats)
+	}
+	end := func() {
+		if err := w.End(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w.BeginNvlist("vdev_tree")
+	w.AddString("type", "root")
+	w.AddUInt64("id", 0)
+	w.AddUInt64Array("vdev_stats", stats)
+	w.BeginNvlistArray("children", 2)
+	for i := range uint64(2) {
+		w.AddString("type", "mirror")
+		w.AddUInt64("id", i)
+		w.AddUInt64Array("vdev_stats", stats)
+		w.BeginNvlistArray("children", 2)
+		disk(2 * i)
+		end()
+		disk(2*i + 1)
+		end()
+		end()
+	}
+	w.BeginNvlistArray("l2cache", 1)
+	disk(4)
+	end()
+	end()
+	end()
+	return w.Data
+}
+
+func TestDecodeDatasetProps(t *testing.T) {
+	data := datasetPropsFixture(t)
+
+	var want, got DatasetProps
+	r := nvlist.NVListReader{Data: data}
+	if err := want.parsePropsHandWritten(&r); err != nil {
+		t.Fatal(err)
+	}
+	r = nvlist.NVListReader{Data: data}
+	if err := got.DecodeNvlist(&r); err != nil {
+		t.Fatalf("DecodeNvlist() failed: %v", err)
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got.LogicalUsed != 1011 {
+		t.Errorf("got logicalused %d, want 1011", got.LogicalUsed)
+	}
+
+	w := nvlist.NVListWriter{}
+	w.BeginNvlist("used")
+	w.AddString("value", "1")
+	w.End()
+	w.End()
+	r = nvlist.NVListReader{Data: w.Data}
+	if err := got.DecodeNvlist(&r); err == nil || err.Error() != "invalid type for used" {
+		t.Errorf("expected invalid type error, got %v", err)
+	}
+}
+
+func TestDecodeVdevs(t *testing.T) {
+	data := vdevTreeFixture(t)
+
+	var want Vdev
+	r := nvlist.NVListReader{Data: data}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Unmarshal(reflect.ValueOf(&want)); err != nil {
+		t.Fatal(err)
+	}
+
+	r = nvlist.NVListReader{Data: data}
+	if _, err := r.Next(); err != nil {
+		t.Fatal(err)
+	}
+	got := &Vdev{}
+	if err := got.DecodeNvlist(&r); err != nil {
+		t.Fatalf("DecodeNvlist() failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, &want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if len(got.Children) != 2 || len(got.Children[1].Children) != 2 || len(got.L2Cache) != 1 {
+		t.Errorf("unexpected vdev tree %+v", got)
+	}
+}
+
+// parseValueHandWritten and parsePropsHandWritten are the hand-written predecessors of the generated
+// DatasetProps decoder, kept as reference for tests and benchmarks.
+func (d *DatasetProps) parseValueHandWritten(r *nvlist.NVListReader, propName string) error {
+	for {
+		token, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		if r.Name() == "value" {
+			switch propName {
+			case "objsetid":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for objsetid")
+				}
+				d.ObjsetID = r.UInt64()
+			case "available":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for available")
+				}
+				d.Available = r.UInt64()
+			case "compressratio":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for compressratio")
+				}
+				d.CompressRatio = r.UInt64()
+			case "used":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for used")
+				}
+				d.Used = r.UInt64()
+			case "usedbychildren":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for usedbychildren")
+				}
+				d.UsedByChildren = r.UInt64()
+			case "usedbydataset":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for usedbydataset")
+				}
+				d.UsedByDataset = r.UInt64()
+			case "usedbyrefreservation":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for usedbyrefreservation")
+				}
+				d.UsedByRefReservation = r.UInt64()
+			case "usedbysnapshots":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for usedbysnapshots")
+				}
+				d.UsedBySnapshots = r.UInt64()
+			case "referenced":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for referenced")
+				}
+				d.Referenced = r.UInt64()
+			case "refcompressratio":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for refcompressratio")
+				}
+				d.RefCompressRatio = r.UInt64()
+			case "logicalreferenced":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for logicalreferenced")
+				}
+				d.LogicalReferenced = r.UInt64()
+			case "logicalused":
+				if token != nvlist.TypeUint64 {
+					return fmt.Errorf("invalid type for logicalused")
+				}
+				d.LogicalUsed = r.UInt64()
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DatasetProps) parsePropsHandWritten(r *nvlist.NVListReader) error {
+	for {
+		token, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		if token == nvlist.TypeNvlist {
+			err = d.parseValueHandWritten(r, r.Name())
+			if err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("expected nvlist, got %v", token)
+		}
+	}
+
+	return nil
+}
+
+func BenchmarkDatasetPropsHandWritten(b *testing.B) {
+	data := datasetPropsFixture(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		r := nvlist.NVListReader{Data: data}
+		props := DatasetProps{}
+		if err := props.parsePropsHandWritten(&r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDatasetPropsGenerated(b *testing.B) {
+	data := datasetPropsFixture(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		r := nvlist.NVListReader{Data: data}
+		props := DatasetProps{}
+		if err := props.DecodeNvlist(&r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDatasetPropsUnmarshal(b *testing.B) {
+	data := datasetPropsFixture(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		var props map[string]struct {
+			Value any `nvlist:"value"`
+		}
+		if err := nvlist.Unmarshal(data, &props); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVdevsGenerated(b *testing.B) {
+	data := vdevTreeFixture(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		r := nvlist.NVListReader{Data: data}
+		if _, err := r.Next(); err != nil {
+			b.Fatal(err)
+		}
+		vdev := &Vdev{}
+		if err := vdev.DecodeNvlist(&r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVdevsUnmarshal(b *testing.B) {
+	data := vdevTreeFixture(b)
+	b.ReportAllocs()
+	for b.Loop() {
+		var tree struct {
+			Root Vdev `nvlist:"vdev_tree"`
+		}
+		if err := nvlist.Unmarshal(data, &tree); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
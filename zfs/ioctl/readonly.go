@@ -0,0 +1,37 @@
+package ioctl
+
+import "fmt"
+
+// readOnlyIoctls are the ioctls that do not modify pools, datasets or the kernel state.
+var readOnlyIoctls = map[Ioctl]bool{
+	ZFS_IOC_POOL_CONFIGS:       true,
+	ZFS_IOC_POOL_STATS:         true,
+	ZFS_IOC_POOL_GET_PROPS:     true,
+	ZFS_IOC_OBJSET_STATS:       true,
+	ZFS_IOC_OBJSET_ZPLPROPS:    true,
+	ZFS_IOC_OBJSET_RECVD_PROPS: true,
+	ZFS_IOC_DATASET_LIST_NEXT:  true,
+	ZFS_IOC_SNAPSHOT_LIST_NEXT: true,
+	ZFS_IOC_ERROR_LOG:          true,
+	ZFS_IOC_GET_HOLDS:          true,
+	ZFS_IOC_USERSPACE_ONE:      true,
+	ZFS_IOC_USERSPACE_MANY:     true,
+	ZFS_IOC_EVENTS_NEXT:        true,
+}
+
+// ReadOnlyHandle is a Handle that only passes ioctls which do not modify anything to Handle. All other ioctls
+// fail with ErrForbiddenIoctl without reaching Handle.
+type ReadOnlyHandle struct {
+	Handle Handle
+}
+
+func (h *ReadOnlyHandle) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	if !readOnlyIoctls[ioctl] {
+		return fmt.Errorf("%v for %q: %w", ioctl, cmd.GetName(), ErrForbiddenIoctl)
+	}
+	return h.Handle.Ioctl(ioctl, cmd, request, config, resp)
+}
+
+func (h *ReadOnlyHandle) ModuleVersion() string {
+	return moduleVersion(h.Handle)
+}
@@ -0,0 +1,54 @@
+package ioctl
+
+import (
+	"errors"
+	"testing"
+)
+
+// countingHandle counts the ioctls passed to it.
+type countingHandle struct {
+	calls int
+}
+
+func (h *countingHandle) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	h.calls++
+	return nil
+}
+
+func TestReadOnlyHandle(t *testing.T) {
+	tests := []struct {
+		ioctl   Ioctl
+		allowed bool
+	}{
+		{ZFS_IOC_POOL_CONFIGS, true},
+		{ZFS_IOC_POOL_STATS, true},
+		{ZFS_IOC_POOL_GET_PROPS, true},
+		{ZFS_IOC_DATASET_LIST_NEXT, true},
+		{ZFS_IOC_SNAPSHOT_LIST_NEXT, true},
+		{ZFS_IOC_OBJSET_STATS, true},
+		{ZFS_IOC_EVENTS_NEXT, true},
+		{ZFS_IOC_POOL_DESTROY, false},
+		{ZFS_IOC_POOL_EXPORT, false},
+		{ZFS_IOC_DESTROY, false},
+		{ZFS_IOC_DESTROY_SNAPS, false},
+		{ZFS_IOC_ROLLBACK, false},
+		{ZFS_IOC_SET_PROP, false},
+		{ZFS_IOC_INJECT_FAULT, false},
+		{ZFS_IOC_USERSPACE_UPGRADE, false},
+		{ZFS_IOC_EVENTS_CLEAR, false},
+		{ZFS_IOC_LAST, false},
+	}
+	for _, test := range tests {
+		inner := &countingHandle{}
+		h := &ReadOnlyHandle{Handle: inner}
+		cmd := Cmd{}
+		cmd.SetName("tank")
+		err := h.Ioctl(test.ioctl, &cmd, nil, nil, nil)
+		if test.allowed && (err != nil || inner.calls != 1) {
+			t.Errorf("%v: got %v with %d calls, want it to be passed on", test.ioctl, err, inner.calls)
+		}
+		if !test.allowed && (!errors.Is(err, ErrForbiddenIoctl) || inner.calls != 0) {
+			t.Errorf("%v: got %v with %d calls, want ErrForbiddenIoctl", test.ioctl, err, inner.calls)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package ioctl
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// ReopeningHandle is a Handle that opens the ZFS device node with Open on first use, so that the exporter can
+// start before the kernel module is loaded. If the module was unloaded or reloaded, the device node is opened
+// again. While Open fails, ioctls fail with ErrUnavailable.
+type ReopeningHandle struct {
+	Open func() (Handle, error)
+
+	mu     sync.Mutex
+	handle Handle
+	// opened counts the calls of Open that succeeded, it tells apart the handles.
+	opened int
+}
+
+// Available opens the device node unless it is open already. It fails with ErrUnavailable if the device node
+// can not be opened.
+func (h *ReopeningHandle) Available() error {
+	_, _, err := h.get()
+	return err
+}
+
+func (h *ReopeningHandle) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	handle, opened, err := h.get()
+	if err != nil {
+		return err
+	}
+	err = handle.Ioctl(ioctl, cmd, request, config, resp)
+	if !errors.Is(err, unix.ENODEV) && !errors.Is(err, unix.EBADF) {
+		return err
+	}
+
+	// The device node no longer refers to a loaded kernel module, retry once with a new one.
+	h.reset(opened)
+	handle, _, err = h.get()
+	if err != nil {
+		return err
+	}
+	return handle.Ioctl(ioctl, cmd, request, config, resp)
+}
+
+// ModuleVersion returns the version of the kernel module of the open device node. It does not open the device
+// node, if it is not open the version is not known.
+func (h *ReopeningHandle) ModuleVersion() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handle == nil {
+		return ""
+	}
+	return moduleVersion(h.handle)
+}
+
+// get returns the open handle and the number of opens it resulted from, opening it if needed.
+func (h *ReopeningHandle) get() (Handle, int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handle == nil {
+		handle, err := h.Open()
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+		h.handle = handle
+		h.opened++
+	}
+	return h.handle, h.opened, nil
+}
+
+// reset drops the handle resulting from the given number of opens, unless another ioctl reopened it already.
+// The handle is not closed, as stalled ioctls may still use it. Its file is closed once it is garbage
+// collected.
+func (h *ReopeningHandle) reset(opened int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.opened == opened {
+		h.handle = nil
+	}
+}
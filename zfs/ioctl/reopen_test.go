@@ -0,0 +1,93 @@
+package ioctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// errnoHandle fails all ioctls with errno, or succeeds if it is zero.
+type errnoHandle struct {
+	errno   unix.Errno
+	calls   int
+	version string
+}
+
+func (h *errnoHandle) ModuleVersion() string {
+	return h.version
+}
+
+func (h *errnoHandle) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	h.calls++
+	if h.errno != 0 {
+		return &ZFSError{Ioctl: ioctl, Name: cmd.GetName(), Errno: h.errno}
+	}
+	return nil
+}
+
+func TestReopeningHandle(t *testing.T) {
+	var handles []*errnoHandle
+	var openErr error
+	h := &ReopeningHandle{Open: func() (Handle, error) {
+		if openErr != nil {
+			return nil, openErr
+		}
+		handle := &errnoHandle{version: fmt.Sprintf("2.%d", len(handles)+1)}
+		handles = append(handles, handle)
+		return handle, nil
+	}}
+	ioctl := func() error {
+		cmd := Cmd{}
+		return h.Ioctl(ZFS_IOC_POOL_CONFIGS, &cmd, nil, nil, nil)
+	}
+
+	// The kernel module is not loaded yet.
+	openErr = os.ErrNotExist
+	if err := h.Available(); !errors.Is(err, ErrUnavailable) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want ErrUnavailable", err)
+	}
+	if err := ioctl(); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("got %v, want ErrUnavailable", err)
+	}
+
+	if v := h.ModuleVersion(); v != "" {
+		t.Fatalf("got version %q before opening, want none", v)
+	}
+
+	openErr = nil
+	for range 2 {
+		if err := ioctl(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(handles) != 1 || handles[0].calls != 2 {
+		t.Fatalf("got %d opens, want a single one for both ioctls", len(handles))
+	}
+
+	// The module was reloaded, the ioctl is retried with a new handle.
+	handles[0].errno = unix.ENODEV
+	if err := ioctl(); err != nil {
+		t.Fatal(err)
+	}
+	if len(handles) != 2 || handles[1].calls != 1 {
+		t.Fatalf("got %d opens, want the handle to be reopened", len(handles))
+	}
+	if v := h.ModuleVersion(); v != "2.2" {
+		t.Errorf("got version %q, want the one of the reopened handle", v)
+	}
+
+	// Other errors are returned without reopening.
+	handles[1].errno = unix.EIO
+	if err := ioctl(); !errors.Is(err, unix.EIO) || len(handles) != 2 {
+		t.Errorf("got %v with %d opens, want EIO without reopening", err, len(handles))
+	}
+
+	// ENXIO means that the pool does not exist, not that the device node is stale.
+	handles[1].errno = unix.ENXIO
+	if err := ioctl(); !errors.Is(err, unix.ENXIO) || len(handles) != 2 {
+		t.Errorf("got %v with %d opens, want ENXIO without reopening", err, len(handles))
+	}
+}
@@ -0,0 +1,152 @@
+package ioctl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// fixture is an ioctl recorded by Recorder. It is keyed by the ioctl and the name and cookie of the Cmd it
+// was issued with, and holds the errno and the name, cookie and response nvlist the kernel returned.
+type fixture struct {
+	Ioctl  string `json:"ioctl"`
+	Name   string `json:"name"`
+	Cookie uint64 `json:"cookie"`
+
+	Errno      unix.Errno `json:"errno"`
+	RespName   string     `json:"resp_name"`
+	RespCookie uint64     `json:"resp_cookie"`
+	Response   []byte     `json:"response"`
+}
+
+// fixturePath returns the path of the fixture for an ioctl issued with the given name and cookie.
+func fixturePath(dir string, ioctl Ioctl, name string, cookie uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%v-%s-%d.json", ioctl, url.PathEscape(name), cookie))
+}
+
+// versionFile is the file in the recording directory holding the version of the kernel module.
+const versionFile = "version"
+
+// Recorder is a Handle that passes ioctls to Handle and records them to Dir, one JSON file per ioctl, so
+// that they can be replayed by ReplayHandle. Only the name, cookie and response nvlist are recorded, the
+// request and config nvlists are neither recorded nor used to tell ioctls apart. The version of the kernel
+// module is recorded as well, whenever it changes.
+type Recorder struct {
+	Handle Handle
+	Dir    string
+
+	mu sync.Mutex
+	// version is the last recorded version of the kernel module.
+	version string
+}
+
+func (r *Recorder) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	f := fixture{Ioctl: ioctl.String(), Name: cmd.GetName(), Cookie: cmd.Cookie}
+	err := r.Handle.Ioctl(ioctl, cmd, request, config, resp)
+	if versionErr := r.recordVersion(); versionErr != nil {
+		return versionErr
+	}
+	if err != nil && !errors.As(err, &f.Errno) {
+		// Only errors returned by the kernel can be replayed.
+		return err
+	}
+	if err == nil {
+		f.RespName = cmd.GetName()
+		f.RespCookie = cmd.Cookie
+		if resp != nil && *resp != nil {
+			f.Response = (*resp)[:min(cmd.Nvlist_dst_size, uint64(len(*resp)))]
+		}
+	}
+
+	data, jsonErr := json.MarshalIndent(f, "", "  ")
+	if jsonErr != nil {
+		return jsonErr
+	}
+	if writeErr := os.WriteFile(fixturePath(r.Dir, ioctl, f.Name, f.Cookie), data, 0o644); writeErr != nil {
+		return fmt.Errorf("error recording %v: %w", ioctl, writeErr)
+	}
+	return err
+}
+
+func (r *Recorder) ModuleVersion() string {
+	return moduleVersion(r.Handle)
+}
+
+// recordVersion records the version of the kernel module of Handle to Dir if it changed since it was last
+// recorded.
+func (r *Recorder) recordVersion() error {
+	version := moduleVersion(r.Handle)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if version == r.version {
+		return nil
+	}
+	if err := os.WriteFile(filepath.Join(r.Dir, versionFile), []byte(version+"\n"), 0o644); err != nil {
+		return fmt.Errorf("error recording module version: %w", err)
+	}
+	r.version = version
+	return nil
+}
+
+// ReplayHandle is a Handle that serves ioctls recorded by Recorder from Dir. Responses that do not fit into
+// the response buffer fail with ENOMEM like the kernel does. Ioctls that were not recorded fail with ESRCH
+// for ZFS_IOC_DATASET_LIST_NEXT and ZFS_IOC_SNAPSHOT_LIST_NEXT, which ends the iteration, and with ENOENT
+// otherwise.
+type ReplayHandle struct {
+	Dir string
+}
+
+func (h *ReplayHandle) Ioctl(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp *[]byte) error {
+	return doIoctl(h, ioctl, cmd, request, config, resp)
+}
+
+// ModuleVersion returns the recorded version of the kernel module. Recordings of systems whose version was
+// not known have none.
+func (h *ReplayHandle) ModuleVersion() string {
+	data, err := os.ReadFile(filepath.Join(h.Dir, versionFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (h *ReplayHandle) ioctlOnce(ioctl Ioctl, cmd *Cmd, request []byte, config []byte, resp []byte) error {
+	data, err := os.ReadFile(fixturePath(h.Dir, ioctl, cmd.GetName(), cmd.Cookie))
+	if os.IsNotExist(err) {
+		if ioctl == ZFS_IOC_DATASET_LIST_NEXT || ioctl == ZFS_IOC_SNAPSHOT_LIST_NEXT {
+			return unix.ESRCH
+		}
+		return unix.ENOENT
+	}
+	if err != nil {
+		return err
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("error replaying %v: %w", ioctl, err)
+	}
+
+	if f.Errno != 0 {
+		return f.Errno
+	}
+	if resp != nil {
+		if len(f.Response) > len(resp) {
+			cmd.Nvlist_dst_size = uint64(len(f.Response))
+			return unix.ENOMEM
+		}
+		copy(resp, f.Response)
+		cmd.Nvlist_dst_size = uint64(len(f.Response))
+	}
+	if err := cmd.SetName(f.RespName); err != nil {
+		return err
+	}
+	cmd.Cookie = f.RespCookie
+	return nil
+}
@@ -0,0 +1,165 @@
+package ioctl
+
+import "time"
+
+// Indices into the scan_stats array of the root vdev, see pool_scan_stat_t. Older kernel modules return
+// fewer fields.
+const (
+	ScanStats_pss_func                       = iota /* pool_scan_func_t */
+	ScanStats_pss_state                             /* dsl_scan_state_t */
+	ScanStats_pss_start_time                        /* scan start time */
+	ScanStats_pss_end_time                          /* scan end time */
+	ScanStats_pss_to_examine                        /* total bytes to scan */
+	ScanStats_pss_examined                          /* total bytes located by scanner */
+	ScanStats_pss_skipped                           /* total bytes skipped by scanner */
+	ScanStats_pss_processed                         /* total processed bytes */
+	ScanStats_pss_errors                            /* scan errors	*/
+	ScanStats_pss_pass_exam                         /* examined bytes per scan pass */
+	ScanStats_pss_pass_start                        /* start time of a scan pass */
+	ScanStats_pss_pass_scrub_pause                  /* pause time of a scrub pass */
+	ScanStats_pss_pass_scrub_spent_paused           /* cumulative time scrub spent paused */
+	ScanStats_pss_pass_issued                       /* issued bytes per scan pass */
+	ScanStats_pss_issued                            /* total bytes checked by scanner */
+	ScanStats_pss_error_scrub_func                  /* pool_scan_func_t */
+	ScanStats_pss_error_scrub_state                 /* dsl_scan_state_t */
+	ScanStats_pss_error_scrub_start                 /* error scrub start time */
+	ScanStats_pss_error_scrub_end                   /* error scrub end time */
+	ScanStats_pss_error_scrub_examined              /* error blocks issued I/O */
+	ScanStats_pss_error_scrub_to_be_examined        /* error blocks to be issued I/O */
+	ScanStats_pss_pass_error_scrub_pause            /* error scrub pause time in milliseconds */
+)
+
+const (
+	scanFunc_NONE       = iota
+	scanFunc_SCRUB      /* scrub */
+	scanFunc_RESILVER   /* resilver */
+	scanFunc_ERRORSCRUB /* error scrub */
+)
+
+const (
+	scanState_NONE           = iota
+	scanState_SCANNING       /* scan in progress */
+	scanState_FINISHED       /* scan completed */
+	scanState_CANCELED       /* scan canceled */
+	scanState_ERRORSCRUBBING /* error scrub in progress */
+)
+
+var ScanStates = [...]string{
+	"NONE",
+	"SCANNING",
+	"FINISHED",
+	"CANCELED",
+	"ERRORSCRUBBING",
+	"UNKNOWN",
+}
+
+func ScanFuncString(f uint64) string {
+	switch f {
+	case scanFunc_NONE:
+		return "NONE"
+	case scanFunc_SCRUB:
+		return "SCRUB"
+	case scanFunc_RESILVER:
+		return "RESILVER"
+	case scanFunc_ERRORSCRUB:
+		return "ERRORSCRUB"
+	}
+
+	return "UNKNOWN"
+}
+
+func ScanStateString(state uint64) string {
+	switch state {
+	case scanState_NONE:
+		return "NONE"
+	case scanState_SCANNING:
+		return "SCANNING"
+	case scanState_FINISHED:
+		return "FINISHED"
+	case scanState_CANCELED:
+		return "CANCELED"
+	case scanState_ERRORSCRUBBING:
+		return "ERRORSCRUBBING"
+	}
+
+	return "UNKNOWN"
+}
+
+// ScanStats is the progress of the last scrub or resilver of a pool, decoded from scan_stats.
+type ScanStats struct {
+	Func  string
+	State string
+
+	StartTime time.Time
+	EndTime   time.Time
+
+	ToExamine uint64
+	Examined  uint64
+	Skipped   uint64
+	Processed uint64
+	Issued    uint64
+	Errors    uint64
+
+	// PassStart is the start of the current pass, which is restarted on import and when a paused scrub is
+	// resumed. PassExamined and PassIssued count the bytes of the current pass.
+	PassStart       time.Time
+	PassExamined    uint64
+	PassIssued      uint64
+	PassSpentPaused time.Duration
+	PassPausedSince time.Time
+}
+
+// ParseScanStats decodes the scan_stats array of the root vdev.
+func ParseScanStats(stats []uint64) (s ScanStats) {
+	field := func(i int) uint64 {
+		if i < len(stats) {
+			return stats[i]
+		}
+		return 0
+	}
+	timestamp := func(i int) time.Time {
+		if field(i) == 0 {
+			return time.Time{}
+		}
+		return time.Unix(int64(field(i)), 0)
+	}
+
+	s.Func = ScanFuncString(field(ScanStats_pss_func))
+	s.State = ScanStateString(field(ScanStats_pss_state))
+	s.StartTime = timestamp(ScanStats_pss_start_time)
+	s.EndTime = timestamp(ScanStats_pss_end_time)
+
+	s.ToExamine = field(ScanStats_pss_to_examine)
+	s.Examined = field(ScanStats_pss_examined)
+	s.Skipped = field(ScanStats_pss_skipped)
+	s.Processed = field(ScanStats_pss_processed)
+	s.Issued = field(ScanStats_pss_issued)
+	s.Errors = field(ScanStats_pss_errors)
+
+	s.PassStart = timestamp(ScanStats_pss_pass_start)
+	s.PassExamined = field(ScanStats_pss_pass_exam)
+	s.PassIssued = field(ScanStats_pss_pass_issued)
+	s.PassSpentPaused = time.Duration(field(ScanStats_pss_pass_scrub_spent_paused)) * time.Second
+	s.PassPausedSince = timestamp(ScanStats_pss_pass_scrub_pause)
+
+	return
+}
+
+// Paused returns whether the scrub is paused.
+func (s *ScanStats) Paused() bool {
+	return !s.PassPausedSince.IsZero()
+}
+
+// PassRate returns the rate in bytes per second at which the current pass issues I/O at now, computed like
+// zpool status does.
+func (s *ScanStats) PassRate(now time.Time) float64 {
+	elapsed := now.Sub(s.PassStart) - s.PassSpentPaused
+	if s.Paused() {
+		elapsed = s.PassPausedSince.Sub(s.PassStart) - s.PassSpentPaused
+	}
+	seconds := elapsed.Truncate(time.Second).Seconds()
+	if seconds < 1 {
+		seconds = 1
+	}
+	return float64(s.PassIssued) / seconds
+}
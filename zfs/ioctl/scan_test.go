@@ -0,0 +1,54 @@
+package ioctl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScanStats(t *testing.T) {
+	stats := make([]uint64, ScanStats_pss_pass_error_scrub_pause+1)
+	stats[ScanStats_pss_func] = scanFunc_SCRUB
+	stats[ScanStats_pss_state] = scanState_SCANNING
+	stats[ScanStats_pss_start_time] = 1700000000
+	stats[ScanStats_pss_to_examine] = 1000
+	stats[ScanStats_pss_examined] = 600
+	stats[ScanStats_pss_pass_start] = 1700000100
+	stats[ScanStats_pss_pass_scrub_spent_paused] = 100
+	stats[ScanStats_pss_pass_issued] = 500
+
+	s := ParseScanStats(stats)
+	if s.Func != "SCRUB" || s.State != "SCANNING" {
+		t.Errorf("got func %s state %s, want SCRUB SCANNING", s.Func, s.State)
+	}
+	if s.StartTime.Unix() != 1700000000 || !s.EndTime.IsZero() {
+		t.Errorf("got start %v end %v", s.StartTime, s.EndTime)
+	}
+	if s.ToExamine != 1000 || s.Examined != 600 {
+		t.Errorf("got to_examine %d examined %d", s.ToExamine, s.Examined)
+	}
+	if s.Paused() {
+		t.Errorf("scrub should not be paused")
+	}
+	// 200s since the pass started, 100s of them paused.
+	if rate := s.PassRate(time.Unix(1700000300, 0)); rate != 5 {
+		t.Errorf("got pass rate %v, want 5", rate)
+	}
+
+	stats[ScanStats_pss_pass_scrub_pause] = 1700000200
+	s = ParseScanStats(stats)
+	if !s.Paused() {
+		t.Errorf("scrub should be paused")
+	}
+	// The rate of a paused scrub does not decay while paused.
+	if rate := s.PassRate(time.Unix(1700009999, 0)); rate != 500 {
+		t.Errorf("got pass rate %v of paused scrub, want 500", rate)
+	}
+}
+
+func TestParseScanStatsShort(t *testing.T) {
+	// Kernel modules before 0.8 do not return the issued bytes.
+	s := ParseScanStats([]uint64{scanFunc_RESILVER, scanState_FINISHED, 1, 2, 3, 4, 5, 6, 7})
+	if s.Func != "RESILVER" || s.State != "FINISHED" || s.Errors != 7 || s.Issued != 0 {
+		t.Errorf("unexpected scan stats %+v", s)
+	}
+}
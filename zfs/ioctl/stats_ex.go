@@ -0,0 +1,37 @@
+package ioctl
+
+// VdevStatsEx holds the extended statistics of a vdev, as shown by zpool iostat -l -q -w. The queue depths
+// are per I/O class. The latency histograms have a bucket per power of two nanoseconds, bucket i counting the
+// I/Os that took at least 2^i and less than 2^(i+1) nanoseconds.
+type VdevStatsEx struct {
+	SyncReadActive   uint64 `nvlist:"vdev_sync_r_active_queue"`
+	SyncWriteActive  uint64 `nvlist:"vdev_sync_w_active_queue"`
+	AsyncReadActive  uint64 `nvlist:"vdev_async_r_active_queue"`
+	AsyncWriteActive uint64 `nvlist:"vdev_async_w_active_queue"`
+	ScrubActive      uint64 `nvlist:"vdev_async_scrub_active_queue"`
+	TrimActive       uint64 `nvlist:"vdev_async_trim_active_queue"`
+	RebuildActive    uint64 `nvlist:"vdev_rebuild_active_queue"`
+
+	SyncReadPending   uint64 `nvlist:"vdev_sync_r_pend_queue"`
+	SyncWritePending  uint64 `nvlist:"vdev_sync_w_pend_queue"`
+	AsyncReadPending  uint64 `nvlist:"vdev_async_r_pend_queue"`
+	AsyncWritePending uint64 `nvlist:"vdev_async_w_pend_queue"`
+	ScrubPending      uint64 `nvlist:"vdev_async_scrub_pend_queue"`
+	TrimPending       uint64 `nvlist:"vdev_async_trim_pend_queue"`
+	RebuildPending    uint64 `nvlist:"vdev_rebuild_pend_queue"`
+
+	// Total and disk latency of reads and writes.
+	TotalReadLatency  []uint64 `nvlist:"vdev_tot_r_lat_histo"`
+	TotalWriteLatency []uint64 `nvlist:"vdev_tot_w_lat_histo"`
+	DiskReadLatency   []uint64 `nvlist:"vdev_disk_r_lat_histo"`
+	DiskWriteLatency  []uint64 `nvlist:"vdev_disk_w_lat_histo"`
+
+	// Time I/Os spent waiting in the queue of their class.
+	SyncReadQueueLatency   []uint64 `nvlist:"vdev_sync_r_lat_histo"`
+	SyncWriteQueueLatency  []uint64 `nvlist:"vdev_sync_w_lat_histo"`
+	AsyncReadQueueLatency  []uint64 `nvlist:"vdev_async_r_lat_histo"`
+	AsyncWriteQueueLatency []uint64 `nvlist:"vdev_async_w_lat_histo"`
+	ScrubQueueLatency      []uint64 `nvlist:"vdev_scrub_histo"`
+	TrimQueueLatency       []uint64 `nvlist:"vdev_trim_histo"`
+	RebuildQueueLatency    []uint64 `nvlist:"vdev_rebuild_histo"`
+}
@@ -1,6 +1,7 @@
 package ioctl
 
 // Cmd is the main data exchange struct for all ZFS ioctl()s aside from nvlists. Mostly generated by godefs.
+// It has the zfs_cmd layout of OpenZFS 0.8 to 2.1, later releases append fields, see ABI.
 type Cmd struct {
 	Name              [4096]byte
 	Nvlist_src        uint64
@@ -1,5 +1,7 @@
 package ioctl
 
+// Indices into the vdev_stats array, see vdev_stat_t. vs_ops and vs_bytes have an entry for every zio type
+// except trim. Older kernel modules return fewer fields.
 const (
 	VDevStats_vs_timestamp              = iota /* time since vdev load	*/
 	VDevStats_vs_state                         /* vdev state		*/
@@ -15,13 +17,12 @@ const (
 	VDevStats_vs_ops_free                      /* operation count: free	*/
 	VDevStats_vs_ops_claim                     /* operation count: claim	*/
 	VDevStats_vs_ops_flush                     /* operation count: flush	*/
-	VDevStats_vs_ops_trim                      /* operation count: trim	*/
+	VDevStats_vs_bytes_null                    /* ignore */
 	VDevStats_vs_bytes_read                    /* bytes: read	*/
 	VDevStats_vs_bytes_write                   /* bytes: write	*/
 	VDevStats_vs_bytes_free                    /* bytes: free	*/
 	VDevStats_vs_bytes_claim                   /* bytes: claim	*/
 	VDevStats_vs_bytes_flush                   /* bytes: flush	*/
-	VDevStats_vs_bytes_trim                    /* bytes: trim	*/
 	VDevStats_vs_read_errors                   /* read errors		*/
 	VDevStats_vs_write_errors                  /* write errors		*/
 	VDevStats_vs_checksum_errors               /* checksum errors	*/
@@ -172,3 +173,67 @@ func VDevStateString(state uint64, aux uint64) string {
 
 	return "UNKNOWN"
 }
+
+const (
+	zioFailureMode_WAIT     = iota /* wait for I/O to complete	*/
+	zioFailureMode_CONTINUE        /* return EIO for new I/O	*/
+	zioFailureMode_PANIC           /* panic the system		*/
+)
+
+// FailModeString returns the value of the failmode pool property as shown by zpool get.
+func FailModeString(mode uint64) string {
+	switch mode {
+	case zioFailureMode_WAIT:
+		return "wait"
+	case zioFailureMode_CONTINUE:
+		return "continue"
+	case zioFailureMode_PANIC:
+		return "panic"
+	}
+
+	return "unknown"
+}
+
+// OnOffString returns the value of a boolean property like autoexpand or autotrim as shown by zpool get.
+func OnOffString(v uint64) string {
+	if v != 0 {
+		return "on"
+	}
+	return "off"
+}
+
+// States of vdev initialization and trimming, see vdev_initializing_state_t and vdev_trim_state_t.
+const (
+	vdevOperation_NONE = iota
+	vdevOperation_ACTIVE
+	vdevOperation_CANCELED
+	vdevOperation_SUSPENDED
+	vdevOperation_COMPLETE
+)
+
+var VDevOperationStates = [...]string{
+	"NONE",
+	"ACTIVE",
+	"CANCELED",
+	"SUSPENDED",
+	"COMPLETE",
+	"UNKNOWN",
+}
+
+// VDevOperationStateString returns the state of the initialization or trimming of a vdev.
+func VDevOperationStateString(state uint64) string {
+	switch state {
+	case vdevOperation_NONE:
+		return "NONE"
+	case vdevOperation_ACTIVE:
+		return "ACTIVE"
+	case vdevOperation_CANCELED:
+		return "CANCELED"
+	case vdevOperation_SUSPENDED:
+		return "SUSPENDED"
+	case vdevOperation_COMPLETE:
+		return "COMPLETE"
+	}
+
+	return "UNKNOWN"
+}
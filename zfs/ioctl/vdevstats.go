@@ -0,0 +1,125 @@
+package ioctl
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// vdevStatsFieldCount is the number of fields of the latest known vdev_stat_t.
+const vdevStatsFieldCount = VDevStats_vs_dio_verify_errors + 1
+
+// VDevStatsFields are the names of the VDevStats_* fields.
+var VDevStatsFields = [vdevStatsFieldCount]string{
+	"vs_timestamp", "vs_state", "vs_aux", "vs_alloc", "vs_space", "vs_dspace", "vs_rsize", "vs_esize",
+	"vs_ops_null", "vs_ops_read", "vs_ops_write", "vs_ops_free", "vs_ops_claim", "vs_ops_flush",
+	"vs_bytes_null", "vs_bytes_read", "vs_bytes_write", "vs_bytes_free", "vs_bytes_claim", "vs_bytes_flush",
+	"vs_read_errors", "vs_write_errors", "vs_checksum_errors", "vs_initialize_errors", "vs_self_healed",
+	"vs_scan_removing", "vs_scan_processed", "vs_fragmentation", "vs_initialize_bytes_done",
+	"vs_initialize_bytes_est", "vs_initialize_state", "vs_initialize_action_time", "vs_checkpoint_space",
+	"vs_resilver_deferred", "vs_slow_ios", "vs_trim_errors", "vs_trim_notsup", "vs_trim_bytes_done",
+	"vs_trim_bytes_est", "vs_trim_state", "vs_trim_action_time", "vs_rebuild_processed",
+	"vs_configured_ashift", "vs_logical_ashift", "vs_physical_ashift", "vs_noalloc", "vs_pspace",
+	"vs_dio_verify_errors",
+}
+
+// fieldRange returns the first n VDevStats_* fields.
+func fieldRange(n int) []int {
+	fields := make([]int, n)
+	for i := range fields {
+		fields[i] = i
+	}
+	return fields
+}
+
+// vdevStatsLegacyFields is the layout of vdev_stat_t before OpenZFS 0.8, which inserted vs_initialize_errors.
+// Since 0.8 fields were only appended, so the vdev_stats of all later releases are a prefix of the
+// VDevStats_* fields.
+var vdevStatsLegacyFields = append(fieldRange(VDevStats_vs_checksum_errors+1), VDevStats_vs_self_healed,
+	VDevStats_vs_scan_removing, VDevStats_vs_scan_processed, VDevStats_vs_fragmentation)
+
+// vdevStatsLengthV08 is the length of vdev_stats in OpenZFS 0.8.
+const vdevStatsLengthV08 = VDevStats_vs_trim_action_time + 1
+
+// VdevStatsSchema maps the VDevStats_* fields to their index in the vdev_stats arrays returned by a kernel
+// module.
+type VdevStatsSchema struct {
+	indices [vdevStatsFieldCount]int
+}
+
+// NewVdevStatsSchema returns the schema of vdev_stats arrays of length n returned by the kernel module
+// version, as read by ModuleVersion. If the version is unknown, the layout is picked by the length of the
+// array. Fields beyond the end of the array are missing.
+func NewVdevStatsSchema(version string, n int) *VdevStatsSchema {
+	legacy := n < vdevStatsLengthV08
+	if major, minor, ok := parseModuleVersion(version); ok {
+		legacy = major == 0 && minor < 8
+	}
+	fields := vdevStatsLegacyFields
+	if !legacy {
+		fields = fieldRange(min(n, vdevStatsFieldCount))
+	}
+
+	s := &VdevStatsSchema{}
+	for i := range s.indices {
+		s.indices[i] = -1
+	}
+	for i, field := range fields {
+		if i < n {
+			s.indices[field] = i
+		}
+	}
+	return s
+}
+
+// Get returns the field of stats and whether stats has it.
+func (s *VdevStatsSchema) Get(stats []uint64, field int) (uint64, bool) {
+	i := s.indices[field]
+	if i < 0 || i >= len(stats) {
+		return 0, false
+	}
+	return stats[i], true
+}
+
+// Missing returns the names of the fields the kernel module does not return.
+func (s *VdevStatsSchema) Missing() []string {
+	var missing []string
+	for field, i := range s.indices {
+		if i < 0 {
+			missing = append(missing, VDevStatsFields[field])
+		}
+	}
+	return missing
+}
+
+// ModuleVersion returns the version of the loaded ZFS kernel module, e.g. 2.2.4-1.
+func ModuleVersion() (string, error) {
+	data, err := os.ReadFile("/sys/module/zfs/version")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// parseModuleVersion returns the major and minor version of a module version like 2.2.4-1 or
+// zfs-kmod-2.1.5-1.
+func parseModuleVersion(version string) (major int, minor int, ok bool) {
+	version = strings.TrimPrefix(version, "zfs-kmod-")
+	version = strings.TrimPrefix(version, "zfs-")
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	if i := strings.IndexFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		parts[1] = parts[1][:i]
+	}
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
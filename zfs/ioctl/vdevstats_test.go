@@ -0,0 +1,52 @@
+package ioctl
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestVdevStatsSchema(t *testing.T) {
+	stats := make([]uint64, 60)
+	for i := range stats {
+		stats[i] = uint64(i)
+	}
+
+	tests := []struct {
+		version string
+		n       int
+		field   int
+		want    uint64
+		ok      bool
+	}{
+		// 0.7 has no vs_initialize_errors, the following fields are shifted by one.
+		{"0.7.13-1", 27, VDevStats_vs_checksum_errors, 22, true},
+		{"0.7.13-1", 27, VDevStats_vs_initialize_errors, 0, false},
+		{"0.7.13-1", 27, VDevStats_vs_self_healed, 23, true},
+		{"0.7.13-1", 27, VDevStats_vs_fragmentation, 26, true},
+		{"", 27, VDevStats_vs_fragmentation, 26, true},
+		{"0.8.6-1", 41, VDevStats_vs_fragmentation, 27, true},
+		{"0.8.6-1", 41, VDevStats_vs_rebuild_processed, 0, false},
+		{"", 41, VDevStats_vs_trim_action_time, 40, true},
+		{"2.3.1-1", 48, VDevStats_vs_dio_verify_errors, 47, true},
+		{"zfs-kmod-2.2.4-1", 47, VDevStats_vs_dio_verify_errors, 0, false},
+		{"", 47, VDevStats_vs_pspace, 46, true},
+		// A newer module with fields that are unknown to the exporter.
+		{"2.4.99-1", 60, VDevStats_vs_dio_verify_errors, 47, true},
+		// A truncated array must not panic.
+		{"2.2.0-rc1", 10, VDevStats_vs_ops_read, 9, true},
+		{"2.2.0-rc1", 10, VDevStats_vs_read_errors, 0, false},
+	}
+	for _, test := range tests {
+		schema := NewVdevStatsSchema(test.version, test.n)
+		got, ok := schema.Get(stats[:test.n], test.field)
+		if got != test.want || ok != test.ok {
+			t.Errorf("%s with %d fields: got %s %d %v, want %d %v", test.version, test.n, VDevStatsFields[test.field],
+				got, ok, test.want, test.ok)
+		}
+	}
+
+	missing := NewVdevStatsSchema("2.1.5-1", 45).Missing()
+	if !slices.Equal(missing, []string{"vs_noalloc", "vs_pspace", "vs_dio_verify_errors"}) {
+		t.Errorf("got missing fields %v", missing)
+	}
+}
@@ -1,24 +1,30 @@
 // Package nvlist implements encoding and decoding of ZFS-style nvlists with an interface similar to
-// that of encoding/json. It supports "native" encoding and parts of XDR in both big and little endian.
+// that of encoding/json. It decodes both "native" encoding (used by ioctls) and XDR (used on-disk, for
+// example in zpool.cache and vdev labels) in both big and little endian.
 package nvlist
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"slices"
 	"strings"
+	"time"
 	"unsafe"
 )
 
+var timeType = reflect.TypeOf(time.Time{})
+
 var (
-	ErrInvalidEncoding  = errors.New("this nvlist is not in native encoding")
+	ErrInvalidEncoding  = errors.New("this nvlist is neither in native nor in XDR encoding")
 	ErrInvalidEndianess = errors.New("this nvlist is neither in big nor in little endian")
 	ErrInvalidData      = errors.New("this nvlist contains invalid data")
 	ErrInvalidValue     = errors.New("the value provided to unmarshal contains invalid types")
-	ErrUnsupportedType  = errors.New("this nvlist contains an unsupported type (hrtime)")
+	ErrUnsupportedType  = errors.New("this nvlist contains an unsupported type")
 )
 
 // Encoding represents the encoding used for serialization/deserialization
@@ -33,17 +39,29 @@ const (
 	littleEndian          = 0x01
 )
 
-// Unmarshal parses a ZFS-style nvlist in native encoding and with any endianness
+// Unmarshal parses a ZFS-style nvlist in native or XDR encoding and with any endianness. The nvlist is
+// validated while decoding it, see NVListReader.Validate.
 func Unmarshal(data []byte, val interface{}) error {
-	r := NVListReader{Data: data}
+	r := NVListReader{Data: data, Validate: true}
 	return r.Unmarshal(reflect.ValueOf(val))
 }
 
 type NVListReader struct {
 	Data []byte
-	pos  int
-
-	// endianness  binary.ByteOrder
+	// Validate enables strict checks of the nvlist, which should be used for untrusted data such as nvlists
+	// read from disk. Without it, the reader only makes sure that it does not access data out of bounds.
+	Validate bool
+	// MaxDepth limits how deeply embedded nvlists may be nested, DefaultMaxDepth is used if it is zero.
+	MaxDepth int
+
+	pos int
+	// pairPos is the offset of the current pair in Data.
+	pairPos int
+
+	// order is the byte order of the integers in Data. swap is set if that differs from the host's
+	// byte order in native encoding, in which case values are converted before they are accessed.
+	order     binary.ByteOrder
+	swap      bool
 	encoding  Encoding
 	alignment int
 	flags     uint32
@@ -54,6 +72,13 @@ type NVListReader struct {
 	dataPos      int
 	dataLen      int
 	currentToken NVType
+
+	// value holds the current value in native layout and host byte order. For native nvlists in host
+	// byte order it aliases Data, otherwise it is a converted copy.
+	value []byte
+	// frames tracks the embedded nvlists that are currently open to limit their depth, to know the path of
+	// the current pair and to step through nvlist arrays in XDR encoding.
+	frames []listFrame
 }
 
 func (r *NVListReader) readByte() (byte, error) {
@@ -100,8 +125,12 @@ func (r *NVListReader) readNvHeader() error {
 		return ErrInvalidEndianess
 	}
 
-	if e == binary.BigEndian {
-		return fmt.Errorf("conversion from big endian not yet supported")
+	if r.encoding == EncodingXDR {
+		// XDR is always big endian, the header only tells the byte order of the host that wrote it.
+		r.order = binary.BigEndian
+	} else {
+		r.order = e
+		r.swap = endiness != nativeEndianByte()
 	}
 
 	r.skipN(2) // reserved
@@ -116,41 +145,45 @@ func (r *NVListReader) readNvHeader() error {
 		return err
 	}
 
+	if r.Validate && r.version != nvVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidData, r.version)
+	}
+
 	return nil
 }
 
 func (r *NVListReader) readInt32() (i int32, err error) {
-	if r.pos+4 >= len(r.Data) {
+	if r.pos+4 > len(r.Data) {
 		err = ErrInvalidData
 		return
 	}
-	i = int32(binary.NativeEndian.Uint32(r.Data[r.pos:]))
+	i = int32(r.order.Uint32(r.Data[r.pos:]))
 	r.pos += 4
 	return
 }
 
 func (r *NVListReader) readInt16() (i int16, err error) {
-	if r.pos+2 >= len(r.Data) {
+	if r.pos+2 > len(r.Data) {
 		err = ErrInvalidData
 		return
 	}
-	i = int16(binary.NativeEndian.Uint16(r.Data[r.pos:]))
+	i = int16(r.order.Uint16(r.Data[r.pos:]))
 	r.pos += 2
 	return
 }
 
 func (r *NVListReader) readUint32() (i uint32, err error) {
-	if r.pos+4 >= len(r.Data) {
+	if r.pos+4 > len(r.Data) {
 		err = ErrInvalidData
 		return
 	}
-	i = uint32(binary.NativeEndian.Uint32(r.Data[r.pos:]))
+	i = r.order.Uint32(r.Data[r.pos:])
 	r.pos += 4
 	return
 }
 
 func (r *NVListReader) readBytes(n int) (b []byte, err error) {
-	if r.pos+n >= len(r.Data) {
+	if r.pos+n > len(r.Data) {
 		err = ErrInvalidData
 		return
 	}
@@ -159,12 +192,31 @@ func (r *NVListReader) readBytes(n int) (b []byte, err error) {
 	return
 }
 
+// Next advances to the next pair of the nvlist and returns its type. At the end of an (embedded) nvlist it
+// returns io.EOF. Invalid data results in a *DecodeError.
 func (r *NVListReader) Next() (NVType, error) {
+	r.pairPos = r.pos
+	r.nameBytes = nil
+	token, err := r.next()
+	if err == io.EOF {
+		return TypeUnknown, err
+	} else if err != nil {
+		return TypeUnknown, r.decodeError(err)
+	}
+	return token, nil
+}
+
+func (r *NVListReader) next() (NVType, error) {
 	if r.pos == 0 {
 		err := r.readNvHeader()
 		if err != nil {
 			return TypeUnknown, err
 		}
+		r.pairPos = r.pos
+	}
+
+	if r.encoding == EncodingXDR {
+		return r.nextXDR()
 	}
 
 	startPos := r.pos
@@ -177,16 +229,14 @@ func (r *NVListReader) Next() (NVType, error) {
 		return TypeUnknown, ErrInvalidData
 	}
 	if size == 0 { // End indicated by zero size
-		return TypeUnknown, io.EOF
+		return TypeUnknown, r.endList()
 	}
-	if r.pos+int(size) >= len(r.Data) {
+	nextNVPairPos := startPos + int(size)
+	if nextNVPairPos > len(r.Data) {
 		return TypeUnknown, ErrInvalidData
 	}
-
-	nextNVPairPos := startPos + int(size)
-
-	if r.encoding == EncodingXDR {
-		r.skipN(4) // Skip decoded size, it's irrelevant for us
+	if r.Validate && size%int32(r.alignment) != 0 {
+		return TypeUnknown, fmt.Errorf("%w: unaligned pair size %d", ErrInvalidData, size)
 	}
 
 	nameSize, err := r.readInt16()
@@ -223,6 +273,9 @@ func (r *NVListReader) Next() (NVType, error) {
 	if err != nil {
 		return TypeUnknown, err
 	}
+	if r.Validate && bytes.IndexByte(nameBytes, 0x00) != len(nameBytes)-1 {
+		return TypeUnknown, fmt.Errorf("%w: unterminated name", ErrInvalidData)
+	}
 	// Remove the null terminator
 	r.nameBytes = nameBytes[:len(nameBytes)-1]
 
@@ -230,14 +283,48 @@ func (r *NVListReader) Next() (NVType, error) {
 		r.pos += r.alignment - ((r.pos - startPos) % r.alignment)
 	}
 
+	valuePos := r.pos
 	r.dataPos = r.pos
 	r.dataLen = nextNVPairPos - r.pos
+	if r.dataLen < 0 {
+		return TypeUnknown, ErrInvalidData
+	}
 	r.pos = nextNVPairPos
 	r.currentToken = nvType
 
 	if nvType == TypeStringArray {
 		// ignore the space for the pointers
 		r.dataPos += 8 * r.NumElements()
+		r.dataLen -= 8 * r.NumElements()
+		if r.dataLen < 0 {
+			return TypeUnknown, ErrInvalidData
+		}
+	}
+
+	r.value = r.Data[r.dataPos : r.dataPos+r.dataLen]
+	if r.swap {
+		r.value = swapValue(nvType, r.value)
+	}
+
+	if err := r.checkValue(nvType); err != nil {
+		return TypeUnknown, err
+	}
+	if r.Validate && nextNVPairPos-valuePos != align8(r.nativeValueSize(nvType)) {
+		return TypeUnknown, fmt.Errorf("%w: value size does not match pair size", ErrInvalidData)
+	}
+
+	switch nvType {
+	case TypeNvlist:
+		// The pairs of the embedded nvlist follow this pair, Next continues with them.
+		if err := r.pushFrame(false); err != nil {
+			return TypeUnknown, err
+		}
+	case TypeNvlistArray:
+		if r.numElements > 0 {
+			if err := r.pushFrame(true); err != nil {
+				return TypeUnknown, err
+			}
+		}
 	}
 
 	return nvType, nil
@@ -259,75 +346,85 @@ func (r *NVListReader) NumElements() int {
 }
 
 func (r *NVListReader) UInt8() uint8 {
-	return r.Data[r.dataPos]
+	return r.value[0]
 }
 
 func (r *NVListReader) UInt8Array() []uint8 {
-	return unsafe.Slice((*uint8)(unsafe.Pointer(unsafe.SliceData(r.Data[r.dataPos:r.dataPos+r.dataLen]))), r.NumElements())
+	return unsafe.Slice((*uint8)(unsafe.Pointer(unsafe.SliceData(r.value))), r.NumElements())
 }
 
 func (r *NVListReader) Int8() int8 {
-	return int8(r.Data[r.dataPos])
+	return int8(r.value[0])
 }
 
 func (r *NVListReader) Int8Array() []int8 {
-	return unsafe.Slice((*int8)(unsafe.Pointer(unsafe.SliceData(r.Data[r.dataPos:r.dataPos+r.dataLen]))), r.NumElements())
+	return unsafe.Slice((*int8)(unsafe.Pointer(unsafe.SliceData(r.value))), r.NumElements())
 }
 
 func (r *NVListReader) UInt16() uint16 {
-	return binary.NativeEndian.Uint16(r.Data[r.dataPos : r.dataPos+r.dataLen])
+	return binary.NativeEndian.Uint16(r.value)
 }
 
 func (r *NVListReader) UInt16Array() []uint16 {
-	return unsafe.Slice((*uint16)(unsafe.Pointer(unsafe.SliceData(r.Data[r.dataPos:r.dataPos+r.dataLen]))), r.NumElements())
+	return unsafe.Slice((*uint16)(r.alignedValue(2)), r.NumElements())
 }
 
 func (r *NVListReader) Int16() int16 {
-	return int16(binary.NativeEndian.Uint16(r.Data[r.dataPos : r.dataPos+r.dataLen]))
+	return int16(binary.NativeEndian.Uint16(r.value))
 }
 
 func (r *NVListReader) Int16Array() []int16 {
-	return unsafe.Slice((*int16)(unsafe.Pointer(unsafe.SliceData(r.Data[r.dataPos:r.dataPos+r.dataLen]))), r.NumElements())
+	return unsafe.Slice((*int16)(r.alignedValue(2)), r.NumElements())
 }
 
 func (r *NVListReader) UInt32() uint32 {
-	return binary.NativeEndian.Uint32(r.Data[r.dataPos : r.dataPos+r.dataLen])
+	return binary.NativeEndian.Uint32(r.value)
 }
 
 func (r *NVListReader) UInt32Array() []uint32 {
-	return unsafe.Slice((*uint32)(unsafe.Pointer(unsafe.SliceData(r.Data[r.dataPos:r.dataPos+r.dataLen]))), r.NumElements())
+	return unsafe.Slice((*uint32)(r.alignedValue(4)), r.NumElements())
 }
 
 func (r *NVListReader) Int32() int32 {
-	return int32(binary.NativeEndian.Uint32(r.Data[r.dataPos : r.dataPos+r.dataLen]))
+	return int32(binary.NativeEndian.Uint32(r.value))
 }
 
 func (r *NVListReader) Int32Array() []int32 {
-	return unsafe.Slice((*int32)(unsafe.Pointer(unsafe.SliceData(r.Data[r.dataPos:r.dataPos+r.dataLen]))), r.NumElements())
+	return unsafe.Slice((*int32)(r.alignedValue(4)), r.NumElements())
 }
 
 func (r *NVListReader) UInt64() uint64 {
-	return binary.NativeEndian.Uint64(r.Data[r.dataPos : r.dataPos+r.dataLen])
+	return binary.NativeEndian.Uint64(r.value)
 }
 
 func (r *NVListReader) UInt64Array() []uint64 {
-	return unsafe.Slice((*uint64)(unsafe.Pointer(unsafe.SliceData(r.Data[r.dataPos:r.dataPos+r.dataLen]))), r.NumElements())
+	return unsafe.Slice((*uint64)(r.alignedValue(8)), r.NumElements())
 }
 
 func (r *NVListReader) Int64() int64 {
-	return int64(binary.NativeEndian.Uint64(r.Data[r.dataPos : r.dataPos+r.dataLen]))
+	return int64(binary.NativeEndian.Uint64(r.value))
 }
 
 func (r *NVListReader) Int64Array() []int64 {
-	return unsafe.Slice((*int64)(unsafe.Pointer(unsafe.SliceData(r.Data[r.dataPos:r.dataPos+r.dataLen]))), r.NumElements())
+	return unsafe.Slice((*int64)(r.alignedValue(8)), r.NumElements())
+}
+
+// Hrtime returns a high-resolution timestamp, which counts nanoseconds on a monotonic clock with an
+// unspecified origin (usually boot). Use HrtimeToTime to convert it into wall clock time.
+func (r *NVListReader) Hrtime() time.Duration {
+	return time.Duration(r.Int64())
+}
+
+func (r *NVListReader) Double() float64 {
+	return math.Float64frombits(r.UInt64())
 }
 
 func (r *NVListReader) Byte() byte {
-	return r.Data[r.dataPos]
+	return r.value[0]
 }
 
 func (r *NVListReader) ByteArray() []byte {
-	return r.Data[r.dataPos : r.dataPos+r.dataLen]
+	return r.value[:r.NumElements()]
 }
 
 func (r *NVListReader) Boolean() (bool, error) {
@@ -344,23 +441,33 @@ func (r *NVListReader) Boolean() (bool, error) {
 func (r *NVListReader) BooleanArray(dst []bool) ([]bool, error) {
 	dst = slices.Grow(dst, r.NumElements())
 	dst = dst[:0]
-	for range r.NumElements() {
-		s, err := r.Boolean()
-		if err != nil {
-			return nil, err
+	for _, v := range r.Int32Array() {
+		switch v {
+		case 0:
+			dst = append(dst, false)
+		case 1:
+			dst = append(dst, true)
+		default:
+			return nil, ErrInvalidData
 		}
-		dst = append(dst, s)
 	}
 	return dst, nil
 }
 
 func (r *NVListReader) BytesUntilDelimiter(delim byte) ([]byte, error) {
-	for i := range r.dataLen {
-		if r.Data[r.dataPos+i] == delim {
-			return r.Data[r.dataPos : r.dataPos+i], nil
+	b, _, err := r.bytesUntilDelimiterAt(0, delim)
+	return b, err
+}
+
+// bytesUntilDelimiterAt returns the bytes of the current value starting at offset up to (excluding) the next
+// delimiter and the offset just past the delimiter.
+func (r *NVListReader) bytesUntilDelimiterAt(offset int, delim byte) ([]byte, int, error) {
+	for i := offset; i < len(r.value); i++ {
+		if r.value[i] == delim {
+			return r.value[offset:i], i + 1, nil
 		}
 	}
-	return nil, ErrInvalidData
+	return nil, 0, ErrInvalidData
 }
 
 func (r *NVListReader) String() (string, error) {
@@ -374,31 +481,31 @@ func (r *NVListReader) String() (string, error) {
 func (r *NVListReader) StringArray(dst []string) ([]string, error) {
 	dst = slices.Grow(dst, r.NumElements())
 	dst = dst[:0]
+	offset := 0
 	for range r.NumElements() {
-		s, err := r.String()
+		b, next, err := r.bytesUntilDelimiterAt(offset, 0x00)
 		if err != nil {
 			return nil, err
 		}
-		dst = append(dst, s)
+		dst = append(dst, unsafe.String(unsafe.SliceData(b), len(b)))
+		offset = next
 	}
 	return dst, nil
 }
 
 func (r *NVListReader) StringArraySafe(dst []string) ([]string, error) {
-	dst = slices.Grow(dst, r.NumElements())
-	dst = dst[:0]
-	for range r.NumElements() {
-		s, err := r.String()
-		if err != nil {
-			return nil, err
-		}
-		dst = append(dst, strings.Clone(s))
+	dst, err := r.StringArray(dst)
+	if err != nil {
+		return nil, err
+	}
+	for i := range dst {
+		dst[i] = strings.Clone(dst[i])
 	}
 	return dst, nil
 }
 
 func (r *NVListReader) Value(val any) error {
-	_, err := binary.Decode(r.Data[r.dataPos:r.dataPos+r.dataLen], binary.NativeEndian, val)
+	_, err := binary.Decode(r.value, binary.NativeEndian, val)
 	if err != nil {
 		return err
 	}
@@ -433,6 +540,14 @@ func (r *NVListReader) Skip() error {
 	return nil
 }
 
+// Unmarshal decodes the remaining pairs of the current nvlist into v, which needs to be a (pointer to a)
+// struct, map or empty interface. Struct fields are matched by their "nvlist" tag or their name. Embedded
+// nvlists can be decoded into structs, pointers to structs and maps, nvlist arrays into slices of those.
+// Pairs without a matching field are skipped.
+//
+// Hrtimes are decoded as time.Duration, or as wall clock time into time.Time fields. time.Time fields also
+// accept {seconds, nanoseconds} int64 and uint64 arrays, which is how zevents store their timestamps.
+// Doubles are decoded as float64.
 func (r *NVListReader) Unmarshal(v reflect.Value) error {
 	if v.Kind() == reflect.Ptr {
 		v = v.Elem()
@@ -447,15 +562,19 @@ func (r *NVListReader) Unmarshal(v reflect.Value) error {
 		t := v.Type()
 		for i := range t.NumField() {
 			field := t.Field(i)
-			tags := strings.Split(field.Tag.Get("nvlist"), ",")
-			name := field.Name
-			if tags[0] != "" {
-				name = tags[0]
+			if !field.IsExported() || field.Tag.Get("nvlist") == "-" {
+				continue
 			}
+			name, _ := parseTag(field)
 			structFieldByName[name] = v.Field(i)
 		}
 	} else if v.Kind() == reflect.Map {
-		// Noop, but valid
+		if v.IsNil() {
+			if !v.CanSet() {
+				return ErrInvalidValue
+			}
+			v.Set(reflect.MakeMap(v.Type()))
+		}
 	} else {
 		return ErrInvalidData
 	}
@@ -471,136 +590,215 @@ func (r *NVListReader) Unmarshal(v reflect.Value) error {
 
 		name := strings.Clone(r.Name())
 
-		setPrimitive := func(value interface{}) {
-			rValue := reflect.ValueOf(value)
-			if rValue.Kind() == reflect.Ptr {
-				rValue = rValue.Elem()
+		// target returns the type the value of the current pair should be decoded into, or nil if there is
+		// nowhere to store it.
+		target := func() reflect.Type {
+			if v.Kind() == reflect.Struct {
+				field, ok := structFieldByName[name]
+				if !ok || !field.CanSet() {
+					return nil
+				}
+				return field.Type()
 			}
+			return v.Type().Elem()
+		}
+
+		set := func(rValue reflect.Value) error {
 			if v.Kind() == reflect.Struct {
-				field := structFieldByName[name]
-				if field.CanSet() {
-					field.Set(rValue)
+				field, ok := structFieldByName[name]
+				if !ok || !field.CanSet() {
+					return nil
+				}
+				if !rValue.Type().AssignableTo(field.Type()) {
+					return fmt.Errorf("%w: cannot decode %v %q into %v", ErrInvalidValue, token, name, field.Type())
 				}
+				field.Set(rValue)
 			} else if v.Kind() == reflect.Map {
+				if !rValue.Type().AssignableTo(v.Type().Elem()) {
+					return fmt.Errorf("%w: cannot decode %v %q into %v", ErrInvalidValue, token, name, v.Type().Elem())
+				}
 				v.SetMapIndex(reflect.ValueOf(name), rValue)
 			}
+			return nil
+		}
+
+		setPrimitive := func(value interface{}) error {
+			rValue := reflect.ValueOf(value)
+			if rValue.Kind() == reflect.Ptr {
+				rValue = rValue.Elem()
+			}
+			if target() == nil {
+				return nil
+			}
+			return set(rValue)
 		}
 
 		switch token {
 		case TypeUnknown:
 			return ErrInvalidData
 		case TypeBoolean:
-			setPrimitive(true)
+			err = setPrimitive(true)
 		case TypeInt16:
-			setPrimitive(r.Int16())
+			err = setPrimitive(r.Int16())
 		case TypeUint16:
-			setPrimitive(r.UInt16())
+			err = setPrimitive(r.UInt16())
 		case TypeInt32:
-			setPrimitive(r.Int32())
+			err = setPrimitive(r.Int32())
 		case TypeUint32:
-			setPrimitive(r.UInt32())
+			err = setPrimitive(r.UInt32())
 		case TypeInt64:
-			setPrimitive(r.Int64())
+			err = setPrimitive(r.Int64())
 		case TypeUint64:
-			setPrimitive(r.UInt64())
+			err = setPrimitive(r.UInt64())
+		case TypeHrtime:
+			if target() == timeType {
+				err = setPrimitive(HrtimeToTime(r.Hrtime()))
+			} else {
+				err = setPrimitive(r.Hrtime())
+			}
+		case TypeDouble:
+			err = setPrimitive(r.Double())
 		case TypeInt8:
-			setPrimitive(r.Int8())
+			err = setPrimitive(r.Int8())
 		case TypeUint8:
-			setPrimitive(r.UInt8())
+			err = setPrimitive(r.UInt8())
 		case TypeByte:
-			setPrimitive(r.Byte())
+			err = setPrimitive(r.Byte())
 		case TypeString:
-			s, err := r.String()
-			if err != nil {
-				return err
+			var s string
+			if s, err = r.String(); err == nil {
+				err = setPrimitive(strings.Clone(s))
 			}
-			setPrimitive(strings.Clone(s))
 		case TypeBooleanValue:
-			b, err := r.Boolean()
-			if err != nil {
-				return err
+			var b bool
+			if b, err = r.Boolean(); err == nil {
+				err = setPrimitive(b)
 			}
-			setPrimitive(b)
 		case TypeInt8Array:
-			setPrimitive(slices.Clone(r.Int8Array()))
+			err = setPrimitive(slices.Clone(r.Int8Array()))
 		case TypeUint8Array:
-			setPrimitive(slices.Clone(r.UInt8Array()))
+			err = setPrimitive(slices.Clone(r.UInt8Array()))
 		case TypeInt16Array:
-			setPrimitive(slices.Clone(r.Int16Array()))
+			err = setPrimitive(slices.Clone(r.Int16Array()))
 		case TypeUint16Array:
-			setPrimitive(slices.Clone(r.UInt16Array()))
+			err = setPrimitive(slices.Clone(r.UInt16Array()))
 		case TypeInt32Array:
-			setPrimitive(slices.Clone(r.Int32Array()))
+			err = setPrimitive(slices.Clone(r.Int32Array()))
 		case TypeUint32Array:
-			setPrimitive(slices.Clone(r.UInt32Array()))
+			err = setPrimitive(slices.Clone(r.UInt32Array()))
 		case TypeInt64Array:
-			setPrimitive(slices.Clone(r.Int64Array()))
+			if target() == timeType {
+				err = setPrimitive(timespecToTime(r.Int64Array()))
+			} else {
+				err = setPrimitive(slices.Clone(r.Int64Array()))
+			}
 		case TypeUint64Array:
-			setPrimitive(slices.Clone(r.UInt64Array()))
+			if target() == timeType {
+				err = setPrimitive(timespecToTime(r.Int64Array()))
+			} else {
+				err = setPrimitive(slices.Clone(r.UInt64Array()))
+			}
 		case TypeByteArray:
-			setPrimitive(slices.Clone(r.ByteArray()))
+			err = setPrimitive(slices.Clone(r.ByteArray()))
 		case TypeStringArray:
-			val, err := r.StringArraySafe(nil)
-			if err != nil {
-				return err
+			var val []string
+			if val, err = r.StringArraySafe(nil); err == nil {
+				err = setPrimitive(val)
 			}
-			setPrimitive(val)
 		case TypeBooleanArray:
-			val, err := r.BooleanArray(nil)
-			if err != nil {
-				return err
+			var val []bool
+			if val, err = r.BooleanArray(nil); err == nil {
+				err = setPrimitive(val)
 			}
-			setPrimitive(val)
 		case TypeNvlist:
-			if v.Kind() == reflect.Struct {
-				field := structFieldByName[name]
-				if field.CanSet() {
-					if err := r.Unmarshal(field); err != nil {
-						return err
-					}
-				}
-			} else if v.Kind() == reflect.Map {
-				valueType := v.Type().Elem()
-				var val reflect.Value
-				if valueType.Kind() == reflect.Interface {
-					val = reflect.ValueOf(make(map[string]interface{}))
-				} else if valueType.Kind() == reflect.Struct {
-					val = reflect.New(valueType)
-				} else if valueType.Kind() == reflect.Map {
-					val = reflect.MakeMap(reflect.MapOf(reflect.TypeOf(""), valueType.Elem()))
-				} else {
-					return fmt.Errorf("complex hybrid types not supported")
-				}
-				if err := r.Unmarshal(val); err != nil {
-					return err
-				}
-				if val.Kind() == reflect.Ptr {
-					v.SetMapIndex(reflect.ValueOf(name), val.Elem())
-				} else {
-					v.SetMapIndex(reflect.ValueOf(name), val)
-				}
-			} else {
-				return fmt.Errorf("invalid pair type (not map or struct)")
+			t := target()
+			if t == nil {
+				err = r.Skip()
+				break
 			}
-		case TypeNvlistArray:
 			var val reflect.Value
-			if v.Kind() == reflect.Struct {
-				return fmt.Errorf("deserializing NVListArrays into structs currently unsupported")
-			} else if v.Kind() == reflect.Map {
-				numElements := r.NumElements()
-				val = reflect.MakeSlice(reflect.SliceOf(reflect.TypeOf(map[string]any{})), numElements, numElements)
-				for i := range numElements { // arraySize is <2^16
-					val.Index(i).Set(reflect.MakeMap(val.Type().Elem()))
-					err := r.Unmarshal(val.Index(i))
-					if err != nil {
-						return err
+			if val, err = r.decodeNvlist(t); err == nil {
+				err = set(val)
+			}
+		case TypeNvlistArray:
+			t := target()
+			if t == nil {
+				for range r.NumElements() {
+					if err = r.Skip(); err != nil {
+						break
 					}
 				}
-				v.SetMapIndex(reflect.ValueOf(name), val)
-			} else {
-				return fmt.Errorf("invalid pair type (not map or struct)")
+				break
 			}
+			var val reflect.Value
+			if val, err = r.decodeNvlistArray(t); err == nil {
+				err = set(val)
+			}
+		}
+		if err != nil {
+			return r.decodeError(err)
 		}
 	}
 	return nil
 }
+
+// decodeNvlist decodes the embedded nvlist of the current pair into a new value of type t.
+func (r *NVListReader) decodeNvlist(t reflect.Type) (reflect.Value, error) {
+	var val reflect.Value
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return reflect.Value{}, fmt.Errorf("%w: cannot decode nvlist into %v", ErrInvalidValue, t)
+		}
+		val = reflect.ValueOf(make(map[string]interface{}))
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return reflect.Value{}, fmt.Errorf("%w: cannot decode nvlist into %v", ErrInvalidValue, t)
+		}
+		val = reflect.MakeMap(t)
+	case reflect.Struct:
+		val = reflect.New(t).Elem()
+	case reflect.Ptr:
+		if k := t.Elem().Kind(); k != reflect.Struct && k != reflect.Map {
+			return reflect.Value{}, fmt.Errorf("%w: cannot decode nvlist into %v", ErrInvalidValue, t)
+		}
+		val = reflect.New(t.Elem())
+	default:
+		return reflect.Value{}, fmt.Errorf("%w: cannot decode nvlist into %v", ErrInvalidValue, t)
+	}
+	if val.Kind() == reflect.Struct {
+		return val, r.Unmarshal(val.Addr())
+	}
+	return val, r.Unmarshal(val)
+}
+
+// decodeNvlistArray decodes all embedded nvlists of the current nvlist array pair into a new slice of type t.
+// Empty interfaces are decoded as []map[string]any.
+func (r *NVListReader) decodeNvlistArray(t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		t = reflect.TypeOf([]map[string]any{})
+	}
+	if t.Kind() != reflect.Slice {
+		return reflect.Value{}, fmt.Errorf("%w: cannot decode nvlist array into %v", ErrInvalidValue, t)
+	}
+
+	numElements := r.NumElements()
+	val := reflect.MakeSlice(t, numElements, numElements)
+	for i := range numElements { // arraySize is <2^16
+		elem, err := r.decodeNvlist(t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		val.Index(i).Set(elem)
+	}
+	return val, nil
+}
+
+// timespecToTime converts a {seconds, nanoseconds} pair into a time.Time. Arrays of other lengths result in
+// the zero time.
+func timespecToTime(ts []int64) time.Time {
+	if len(ts) != 2 {
+		return time.Time{}
+	}
+	return time.Unix(ts[0], ts[1])
+}
@@ -0,0 +1,272 @@
+package nvlist
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func fixtureDisk(id uint64, path string) map[string]any {
+	return map[string]any{
+		"type":       "disk",
+		"id":         id,
+		"guid":       0x1000 + id,
+		"path":       path,
+		"whole_disk": uint64(1),
+		"ashift":     uint64(12),
+	}
+}
+
+// fixturePool is the content of the fixtures in testdata, which hold the same zpool.cache-like nvlist in
+// different encodings. They were encoded independently of this package following libnvpair's nvs_xdr and
+// nvs_native encoders.
+var fixturePool = map[string]any{
+	"tank": map[string]any{
+		"version":       uint64(5000),
+		"name":          "tank",
+		"state":         uint64(0),
+		"txg":           uint64(1234567),
+		"pool_guid":     uint64(0x8d2b0e4c6a1f3e57),
+		"errata":        uint64(0),
+		"hostid":        uint64(0xdeadbeef),
+		"hostname":      "fileserver",
+		"vdev_children": uint64(2),
+		"vdev_tree": map[string]any{
+			"type": "root",
+			"id":   uint64(0),
+			"guid": uint64(0xfeedfacecafebeef),
+			"children": []map[string]any{
+				{
+					"type": "mirror",
+					"id":   uint64(0),
+					"children": []map[string]any{
+						fixtureDisk(0, "/dev/sda1"),
+						fixtureDisk(1, "/dev/sdb1"),
+					},
+				},
+				fixtureDisk(1, "/dev/sdc1"),
+			},
+		},
+		"features_for_read": map[string]any{
+			"com.delphix:hole_birth":    true,
+			"com.delphix:embedded_data": true,
+		},
+		"empty": []map[string]any{},
+		"misc": map[string]any{
+			"byte":    byte(0xab),
+			"int8":    int8(-5),
+			"uint8":   uint8(250),
+			"int16":   int16(-1234),
+			"uint16":  uint16(65000),
+			"int32":   int32(-100000),
+			"uint32":  uint32(4000000000),
+			"int64":   int64(-(1 << 40)),
+			"bool":    true,
+			"bytes":   []byte{1, 2, 3, 4, 5},
+			"int8s":   []int8{-1, 2, -3},
+			"uint8s":  []uint8{1, 2, 3, 4, 5},
+			"int16s":  []int16{-1, 300},
+			"uint16s": []uint16{1, 65535},
+			"int32s":  []int32{-7, 8},
+			"uint32s": []uint32{9, 10, 11},
+			"int64s":  []int64{-12},
+			"uint64s": []uint64{13, 14},
+			"strings": []string{"a", "bcd", "", "efghi"},
+			"bools":   []bool{true, false, true},
+		},
+	},
+}
+
+func TestUnmarshalFixtures(t *testing.T) {
+	for _, name := range []string{"native-le.nvlist", "native-be.nvlist", "zpool.cache.xdr"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := map[string]any{}
+			if err := Unmarshal(data, &out); err != nil {
+				t.Fatalf("Unmarshal() failed: %v", err)
+			}
+			if !reflect.DeepEqual(out, fixturePool) {
+				t.Errorf("decoded nvlist mismatch:\ngot:  %#v\nwant: %#v", out, fixturePool)
+			}
+
+			// Skipping has to consume exactly the whole list, including the embedded ones.
+			r := NVListReader{Data: data}
+			if _, err := r.Next(); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Skip(); err != nil {
+				t.Fatalf("Skip() failed: %v", err)
+			}
+			if _, err := r.Next(); err == nil {
+				t.Errorf("expected end of list after skipping the only pair")
+			}
+		})
+	}
+}
+
+type fixtureVdev struct {
+	Type     string         `nvlist:"type"`
+	ID       uint64         `nvlist:"id"`
+	Path     string         `nvlist:"path,omitempty"`
+	Children []*fixtureVdev `nvlist:"children,omitempty"`
+}
+
+type fixtureConfig struct {
+	Name     string          `nvlist:"name"`
+	Tree     *fixtureVdev    `nvlist:"vdev_tree"`
+	Features map[string]bool `nvlist:"features_for_read"`
+	Empty    []fixtureVdev   `nvlist:"empty"`
+	Ignored  string          `nvlist:"-"`
+}
+
+func TestUnmarshalStructs(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "zpool.cache.xdr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]fixtureConfig{}
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+
+	disk := func(id uint64, path string) *fixtureVdev {
+		return &fixtureVdev{Type: "disk", ID: id, Path: path}
+	}
+	want := map[string]fixtureConfig{
+		"tank": {
+			Name: "tank",
+			Tree: &fixtureVdev{
+				Type: "root",
+				Children: []*fixtureVdev{
+					{Type: "mirror", Children: []*fixtureVdev{disk(0, "/dev/sda1"), disk(1, "/dev/sdb1")}},
+					disk(1, "/dev/sdc1"),
+				},
+			},
+			Features: map[string]bool{
+				"com.delphix:hole_birth":    true,
+				"com.delphix:embedded_data": true,
+			},
+			Empty: []fixtureVdev{},
+		},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("decoded structs mismatch:\ngot:  %#v\nwant: %#v", out, want)
+	}
+}
+
+func TestUnmarshalTypeMismatch(t *testing.T) {
+	data, err := Marshal(map[string]any{"name": uint64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Name string `nvlist:"name"`
+	}
+	if err := Unmarshal(data, &out); !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("expected ErrInvalidValue, got %v", err)
+	}
+}
+
+func TestUnmarshalTimes(t *testing.T) {
+	w := NVListWriter{}
+	w.AddHrtime("uptime", 90*time.Second)
+	w.AddHrtime("created", 0)
+	w.AddInt64Array("time", []int64{1700000000, 500})
+	w.AddDouble("ratio", 1.25)
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct {
+		Uptime  time.Duration `nvlist:"uptime"`
+		Created time.Time     `nvlist:"created"`
+		Time    time.Time     `nvlist:"time"`
+		Ratio   float64       `nvlist:"ratio"`
+	}
+	if err := Unmarshal(w.Data, &out); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+	if out.Uptime != 90*time.Second {
+		t.Errorf("got uptime %v, want 90s", out.Uptime)
+	}
+	// An hrtime of zero is the origin of the monotonic clock, which has to be in the past.
+	if out.Created.IsZero() || !out.Created.Before(time.Now()) {
+		t.Errorf("got created %v, want a time in the past", out.Created)
+	}
+	if want := time.Unix(1700000000, 500); !out.Time.Equal(want) {
+		t.Errorf("got time %v, want %v", out.Time, want)
+	}
+	if out.Ratio != 1.25 {
+		t.Errorf("got ratio %v, want 1.25", out.Ratio)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	w := NVListWriter{}
+	w.BeginNvlist("tank")
+	w.BeginNvlistArray("children", 2)
+	w.AddString("path", "/dev/sda")
+	w.End()
+	w.AddUInt64("id", 1)
+	w.AddString("path", "/dev/vd")
+	w.End()
+	w.End()
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	data := w.Data
+
+	// The second path exactly fills its 8 byte slot, overwriting its terminator has to be detected and
+	// reported with the path of the pair.
+	i := len(data) - 1
+	for data[i] != 'd' {
+		i--
+	}
+	data[i+1] = 'x'
+
+	var out map[string]any
+	err := Unmarshal(data, &out)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || !errors.Is(err, ErrInvalidData) {
+		t.Fatalf("expected a DecodeError wrapping ErrInvalidData, got %v", err)
+	}
+	if want := "tank.children[1].path"; decodeErr.Path != want {
+		t.Errorf("got path %q, want %q", decodeErr.Path, want)
+	}
+
+	// The pair size is only checked against the data, so the unterminated string is not noticed without
+	// Validate, but its value must not exceed the pair.
+	r := NVListReader{Data: data}
+	if err := r.Skip(); err != nil {
+		t.Errorf("Skip() failed: %v", err)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	w := NVListWriter{}
+	for range DefaultMaxDepth + 1 {
+		w.BeginNvlist("a")
+	}
+	for range DefaultMaxDepth + 2 {
+		if err := w.End(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NVListReader{Data: w.Data}
+	if err := r.Skip(); !errors.Is(err, ErrMaxDepth) {
+		t.Errorf("expected ErrMaxDepth, got %v", err)
+	}
+	r = NVListReader{Data: w.Data, MaxDepth: DefaultMaxDepth + 1}
+	if err := r.Skip(); err != nil {
+		t.Errorf("Skip() failed: %v", err)
+	}
+}
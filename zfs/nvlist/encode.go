@@ -0,0 +1,480 @@
+package nvlist
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// nvUniqueName is the nvflag ZFS sets on all nvlists it allocates (NV_UNIQUE_NAME)
+	nvUniqueName = 0x1
+	// nvpairHeaderSize is the size of nvpair_t without the trailing name
+	nvpairHeaderSize = 16
+	// nvlistSize is the size of an nvlist_t, which is stored as the value of (embedded) nvlists
+	nvlistSize = 24
+	// pointerSize is the size of the (meaningless) pointers stored in front of string and nvlist arrays
+	pointerSize = 8
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Marshal serializes val into a ZFS-style nvlist in native encoding and host endianness. val needs to be a
+// map with string keys or a struct, optionally behind a pointer. Struct fields are named by their "nvlist"
+// tag, which accepts the same names as Unmarshal plus the options "omitempty" (skip zero values) and
+// "boolean" (encode a true bool as a value-less boolean flag and omit it if false).
+func Marshal(val any) ([]byte, error) {
+	w := NVListWriter{}
+	if err := w.Marshal(reflect.ValueOf(val)); err != nil {
+		return nil, err
+	}
+	return w.Data, nil
+}
+
+// NVListWriter builds a ZFS-style nvlist in native encoding. Pairs are appended to the innermost open list;
+// BeginNvlist and BeginNvlistArray open embedded lists which have to be terminated with End, as does the
+// top-level list. Errors are sticky and returned by End.
+type NVListWriter struct {
+	Data []byte
+
+	// pending holds the number of lists that still need to be terminated per nesting level
+	pending []int
+	err     error
+}
+
+func align8(n int) int {
+	return (n + 7) &^ 7
+}
+
+func nativeEndianByte() byte {
+	if binary.NativeEndian.Uint16([]byte{0x01, 0x00}) == 0x01 {
+		return littleEndian
+	}
+	return bigEndian
+}
+
+func (w *NVListWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+func (w *NVListWriter) writeNvHeader() {
+	w.Data = append(w.Data, byte(EncodingNative), nativeEndianByte(), 0x00, 0x00)
+	w.Data = binary.NativeEndian.AppendUint32(w.Data, 0) // version
+	w.Data = binary.NativeEndian.AppendUint32(w.Data, nvUniqueName)
+	w.pending = append(w.pending, 1)
+}
+
+// pair appends the header of an nvpair and returns the zeroed space reserved for its value.
+func (w *NVListWriter) pair(name string, t NVType, numElements int, valueSize int) []byte {
+	if len(w.pending) == 0 {
+		if len(w.Data) != 0 {
+			w.fail(fmt.Errorf("cannot add %q to an nvlist that has already been ended", name))
+			return make([]byte, valueSize)
+		}
+		w.writeNvHeader()
+	}
+	if strings.IndexByte(name, 0x00) != -1 {
+		w.fail(fmt.Errorf("name %q contains null byte", name))
+	}
+	if len(name)+1 > math.MaxInt16 || numElements > math.MaxInt32 {
+		w.fail(fmt.Errorf("pair %q too large", name))
+	}
+
+	start := len(w.Data)
+	headerSize := align8(nvpairHeaderSize + len(name) + 1)
+	size := headerSize + align8(valueSize)
+	w.Data = append(w.Data, make([]byte, size)...)
+
+	b := w.Data[start:]
+	binary.NativeEndian.PutUint32(b[0:], uint32(size))
+	binary.NativeEndian.PutUint16(b[4:], uint16(len(name)+1))
+	binary.NativeEndian.PutUint32(b[8:], uint32(numElements))
+	binary.NativeEndian.PutUint32(b[12:], uint32(t))
+	copy(b[nvpairHeaderSize:], name)
+
+	return b[headerSize : headerSize+valueSize]
+}
+
+func (w *NVListWriter) AddBoolean(name string) {
+	w.pair(name, TypeBoolean, 0, 0)
+}
+
+func (w *NVListWriter) AddBooleanValue(name string, v bool) {
+	b := w.pair(name, TypeBooleanValue, 1, 4)
+	if v {
+		binary.NativeEndian.PutUint32(b, 1)
+	}
+}
+
+func (w *NVListWriter) AddByte(name string, v byte) {
+	w.pair(name, TypeByte, 1, 1)[0] = v
+}
+
+func (w *NVListWriter) AddInt8(name string, v int8) {
+	w.pair(name, TypeInt8, 1, 1)[0] = byte(v)
+}
+
+func (w *NVListWriter) AddUInt8(name string, v uint8) {
+	w.pair(name, TypeUint8, 1, 1)[0] = v
+}
+
+func (w *NVListWriter) AddInt16(name string, v int16) {
+	binary.NativeEndian.PutUint16(w.pair(name, TypeInt16, 1, 2), uint16(v))
+}
+
+func (w *NVListWriter) AddUInt16(name string, v uint16) {
+	binary.NativeEndian.PutUint16(w.pair(name, TypeUint16, 1, 2), v)
+}
+
+func (w *NVListWriter) AddInt32(name string, v int32) {
+	binary.NativeEndian.PutUint32(w.pair(name, TypeInt32, 1, 4), uint32(v))
+}
+
+func (w *NVListWriter) AddUInt32(name string, v uint32) {
+	binary.NativeEndian.PutUint32(w.pair(name, TypeUint32, 1, 4), v)
+}
+
+func (w *NVListWriter) AddInt64(name string, v int64) {
+	binary.NativeEndian.PutUint64(w.pair(name, TypeInt64, 1, 8), uint64(v))
+}
+
+func (w *NVListWriter) AddUInt64(name string, v uint64) {
+	binary.NativeEndian.PutUint64(w.pair(name, TypeUint64, 1, 8), v)
+}
+
+func (w *NVListWriter) AddHrtime(name string, v time.Duration) {
+	binary.NativeEndian.PutUint64(w.pair(name, TypeHrtime, 1, 8), uint64(v))
+}
+
+func (w *NVListWriter) AddDouble(name string, v float64) {
+	binary.NativeEndian.PutUint64(w.pair(name, TypeDouble, 1, 8), math.Float64bits(v))
+}
+
+func (w *NVListWriter) AddString(name string, v string) {
+	if strings.IndexByte(v, 0x00) != -1 {
+		w.fail(fmt.Errorf("value of %q contains null byte", name))
+	}
+	copy(w.pair(name, TypeString, 1, len(v)+1), v)
+}
+
+func (w *NVListWriter) AddByteArray(name string, v []byte) {
+	copy(w.pair(name, TypeByteArray, len(v), len(v)), v)
+}
+
+func (w *NVListWriter) AddInt8Array(name string, v []int8) {
+	b := w.pair(name, TypeInt8Array, len(v), len(v))
+	for i, e := range v {
+		b[i] = byte(e)
+	}
+}
+
+func (w *NVListWriter) AddUInt8Array(name string, v []uint8) {
+	copy(w.pair(name, TypeUint8Array, len(v), len(v)), v)
+}
+
+func (w *NVListWriter) AddInt16Array(name string, v []int16) {
+	b := w.pair(name, TypeInt16Array, len(v), 2*len(v))
+	for i, e := range v {
+		binary.NativeEndian.PutUint16(b[2*i:], uint16(e))
+	}
+}
+
+func (w *NVListWriter) AddUInt16Array(name string, v []uint16) {
+	b := w.pair(name, TypeUint16Array, len(v), 2*len(v))
+	for i, e := range v {
+		binary.NativeEndian.PutUint16(b[2*i:], e)
+	}
+}
+
+func (w *NVListWriter) AddInt32Array(name string, v []int32) {
+	b := w.pair(name, TypeInt32Array, len(v), 4*len(v))
+	for i, e := range v {
+		binary.NativeEndian.PutUint32(b[4*i:], uint32(e))
+	}
+}
+
+func (w *NVListWriter) AddUInt32Array(name string, v []uint32) {
+	b := w.pair(name, TypeUint32Array, len(v), 4*len(v))
+	for i, e := range v {
+		binary.NativeEndian.PutUint32(b[4*i:], e)
+	}
+}
+
+func (w *NVListWriter) AddInt64Array(name string, v []int64) {
+	b := w.pair(name, TypeInt64Array, len(v), 8*len(v))
+	for i, e := range v {
+		binary.NativeEndian.PutUint64(b[8*i:], uint64(e))
+	}
+}
+
+func (w *NVListWriter) AddUInt64Array(name string, v []uint64) {
+	b := w.pair(name, TypeUint64Array, len(v), 8*len(v))
+	for i, e := range v {
+		binary.NativeEndian.PutUint64(b[8*i:], e)
+	}
+}
+
+func (w *NVListWriter) AddBooleanArray(name string, v []bool) {
+	b := w.pair(name, TypeBooleanArray, len(v), 4*len(v))
+	for i, e := range v {
+		if e {
+			binary.NativeEndian.PutUint32(b[4*i:], 1)
+		}
+	}
+}
+
+func (w *NVListWriter) AddStringArray(name string, v []string) {
+	size := pointerSize * len(v)
+	for _, s := range v {
+		if strings.IndexByte(s, 0x00) != -1 {
+			w.fail(fmt.Errorf("value of %q contains null byte", name))
+		}
+		size += len(s) + 1
+	}
+	b := w.pair(name, TypeStringArray, len(v), size)
+	pos := pointerSize * len(v)
+	for _, s := range v {
+		pos += copy(b[pos:], s) + 1
+	}
+}
+
+// BeginNvlist adds an embedded nvlist. All following pairs are added to it until it is terminated with End.
+func (w *NVListWriter) BeginNvlist(name string) {
+	b := w.pair(name, TypeNvlist, 1, nvlistSize)
+	binary.NativeEndian.PutUint32(b[4:], nvUniqueName)
+	w.pending = append(w.pending, 1)
+}
+
+// BeginNvlistArray adds an array of numElements embedded nvlists. The pairs of each element are added in
+// sequence, each element needs to be terminated with End.
+func (w *NVListWriter) BeginNvlistArray(name string, numElements int) {
+	b := w.pair(name, TypeNvlistArray, numElements, (pointerSize+nvlistSize)*numElements)
+	for i := range numElements {
+		binary.NativeEndian.PutUint32(b[pointerSize*numElements+nvlistSize*i+4:], nvUniqueName)
+	}
+	if numElements > 0 {
+		w.pending = append(w.pending, numElements)
+	}
+}
+
+// End terminates the innermost open nvlist. Ending the top-level list finishes the encoding, after which
+// Data holds the complete nvlist.
+func (w *NVListWriter) End() error {
+	if len(w.pending) == 0 {
+		if len(w.Data) != 0 {
+			return errors.New("no open nvlist to end")
+		}
+		w.writeNvHeader()
+	}
+	w.Data = append(w.Data, 0x00, 0x00, 0x00, 0x00)
+	top := len(w.pending) - 1
+	w.pending[top]--
+	if w.pending[top] == 0 {
+		w.pending = w.pending[:top]
+	}
+	return w.err
+}
+
+type fieldOptions struct {
+	omitEmpty bool
+	boolean   bool
+}
+
+// parseTag splits an nvlist struct tag into the pair name and its options.
+func parseTag(field reflect.StructField) (name string, opts fieldOptions) {
+	tags := strings.Split(field.Tag.Get("nvlist"), ",")
+	name = field.Name
+	if tags[0] != "" {
+		name = tags[0]
+	}
+	for _, opt := range tags[1:] {
+		switch opt {
+		case "omitempty":
+			opts.omitEmpty = true
+		case "boolean":
+			opts.boolean = true
+		}
+	}
+	return
+}
+
+// Marshal adds all fields of the struct or entries of the map v to the innermost open nvlist and terminates
+// it afterwards.
+func (w *NVListWriter) Marshal(v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return fmt.Errorf("%w: cannot marshal nil", ErrInvalidValue)
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := range t.NumField() {
+			field := t.Field(i)
+			if !field.IsExported() || field.Tag.Get("nvlist") == "-" {
+				continue
+			}
+			name, opts := parseTag(field)
+			fv := v.Field(i)
+			if opts.omitEmpty && fv.IsZero() {
+				continue
+			}
+			if err := w.marshalValue(name, fv, opts); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("%w: map keys need to be strings", ErrInvalidValue)
+		}
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int {
+			return strings.Compare(a.String(), b.String())
+		})
+		for _, key := range keys {
+			if err := w.marshalValue(key.String(), v.MapIndex(key), fieldOptions{}); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: cannot marshal %v as nvlist", ErrInvalidValue, v.Type())
+	}
+
+	return w.End()
+}
+
+func (w *NVListWriter) marshalValue(name string, v reflect.Value, opts fieldOptions) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if v.Type() == durationType {
+		w.AddHrtime(name, time.Duration(v.Int()))
+		return w.err
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if opts.boolean {
+			if v.Bool() {
+				w.AddBoolean(name)
+			}
+		} else {
+			w.AddBooleanValue(name, v.Bool())
+		}
+	case reflect.Int8:
+		w.AddInt8(name, int8(v.Int()))
+	case reflect.Uint8:
+		w.AddUInt8(name, uint8(v.Uint()))
+	case reflect.Int16:
+		w.AddInt16(name, int16(v.Int()))
+	case reflect.Uint16:
+		w.AddUInt16(name, uint16(v.Uint()))
+	case reflect.Int32:
+		w.AddInt32(name, int32(v.Int()))
+	case reflect.Uint32:
+		w.AddUInt32(name, uint32(v.Uint()))
+	case reflect.Int64, reflect.Int:
+		w.AddInt64(name, v.Int())
+	case reflect.Uint64, reflect.Uint:
+		w.AddUInt64(name, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		w.AddDouble(name, v.Float())
+	case reflect.String:
+		w.AddString(name, v.String())
+	case reflect.Struct, reflect.Map:
+		w.BeginNvlist(name)
+		return w.Marshal(v)
+	case reflect.Slice, reflect.Array:
+		return w.marshalArray(name, v)
+	default:
+		return fmt.Errorf("%w: cannot marshal %q of type %v", ErrInvalidValue, name, v.Type())
+	}
+	return w.err
+}
+
+func (w *NVListWriter) marshalArray(name string, v reflect.Value) error {
+	n := v.Len()
+	elemType := v.Type().Elem()
+	for elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+
+	switch elemType.Kind() {
+	case reflect.Bool:
+		b := w.pair(name, TypeBooleanArray, n, 4*n)
+		for i := range n {
+			if v.Index(i).Bool() {
+				binary.NativeEndian.PutUint32(b[4*i:], 1)
+			}
+		}
+	case reflect.Int8:
+		b := w.pair(name, TypeInt8Array, n, n)
+		for i := range n {
+			b[i] = byte(v.Index(i).Int())
+		}
+	case reflect.Uint8:
+		b := w.pair(name, TypeByteArray, n, n)
+		for i := range n {
+			b[i] = byte(v.Index(i).Uint())
+		}
+	case reflect.Int16:
+		b := w.pair(name, TypeInt16Array, n, 2*n)
+		for i := range n {
+			binary.NativeEndian.PutUint16(b[2*i:], uint16(v.Index(i).Int()))
+		}
+	case reflect.Uint16:
+		b := w.pair(name, TypeUint16Array, n, 2*n)
+		for i := range n {
+			binary.NativeEndian.PutUint16(b[2*i:], uint16(v.Index(i).Uint()))
+		}
+	case reflect.Int32:
+		b := w.pair(name, TypeInt32Array, n, 4*n)
+		for i := range n {
+			binary.NativeEndian.PutUint32(b[4*i:], uint32(v.Index(i).Int()))
+		}
+	case reflect.Uint32:
+		b := w.pair(name, TypeUint32Array, n, 4*n)
+		for i := range n {
+			binary.NativeEndian.PutUint32(b[4*i:], uint32(v.Index(i).Uint()))
+		}
+	case reflect.Int64, reflect.Int:
+		b := w.pair(name, TypeInt64Array, n, 8*n)
+		for i := range n {
+			binary.NativeEndian.PutUint64(b[8*i:], uint64(v.Index(i).Int()))
+		}
+	case reflect.Uint64, reflect.Uint:
+		b := w.pair(name, TypeUint64Array, n, 8*n)
+		for i := range n {
+			binary.NativeEndian.PutUint64(b[8*i:], v.Index(i).Uint())
+		}
+	case reflect.String:
+		strs := make([]string, n)
+		for i := range n {
+			strs[i] = v.Index(i).String()
+		}
+		w.AddStringArray(name, strs)
+	case reflect.Struct, reflect.Map, reflect.Interface:
+		w.BeginNvlistArray(name, n)
+		for i := range n {
+			if err := w.Marshal(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: cannot marshal %q of type %v", ErrInvalidValue, name, v.Type())
+	}
+	return w.err
+}
@@ -0,0 +1,136 @@
+package nvlist
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+type testVdev struct {
+	Type     string   `nvlist:"type"`
+	ID       uint64   `nvlist:"id"`
+	Path     string   `nvlist:"path,omitempty"`
+	Stats    []uint64 `nvlist:"vdev_stats"`
+	IsLog    bool     `nvlist:"is_log"`
+	Whole    bool     `nvlist:"whole_disk,boolean"`
+	internal int
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	in := map[string]any{
+		"name":     "tank",
+		"version":  uint64(5000),
+		"errors":   int32(-3),
+		"features": []string{"async_destroy", "", "zstd_compress"},
+		"flags":    []bool{true, false, true},
+		"guids":    []uint64{1, 2, 3},
+		"small":    []int16{-1, 2},
+		"blob":     []byte{0xde, 0xad, 0xbe, 0xef, 0x01},
+		"ratio":    1.5,
+		"vdev_tree": testVdev{
+			Type:  "root",
+			Stats: []uint64{7, 8},
+		},
+		"children": []testVdev{
+			{Type: "disk", ID: 0, Path: "/dev/sda", Whole: true},
+			{Type: "disk", ID: 1, IsLog: true},
+		},
+	}
+
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+	if len(data)%4 != 0 {
+		t.Errorf("encoded length %d is not a multiple of 4", len(data))
+	}
+
+	out := map[string]any{}
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+
+	want := map[string]any{
+		"name":     "tank",
+		"version":  uint64(5000),
+		"errors":   int32(-3),
+		"features": []string{"async_destroy", "", "zstd_compress"},
+		"flags":    []bool{true, false, true},
+		"guids":    []uint64{1, 2, 3},
+		"small":    []int16{-1, 2},
+		"blob":     []byte{0xde, 0xad, 0xbe, 0xef, 0x01},
+		"ratio":    1.5,
+		"vdev_tree": map[string]any{
+			"type":       "root",
+			"id":         uint64(0),
+			"vdev_stats": []uint64{7, 8},
+			"is_log":     false,
+		},
+		"children": []map[string]any{
+			{"type": "disk", "id": uint64(0), "path": "/dev/sda", "vdev_stats": []uint64{}, "is_log": false, "whole_disk": true},
+			{"type": "disk", "id": uint64(1), "vdev_stats": []uint64{}, "is_log": true},
+		},
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Errorf("round trip mismatch:\ngot:  %#v\nwant: %#v", out, want)
+	}
+}
+
+func TestNVListWriterStructure(t *testing.T) {
+	w := NVListWriter{}
+	w.AddUInt64("a", 1)
+	w.BeginNvlist("b")
+	w.AddString("c", "d")
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	w.BeginNvlistArray("e", 2)
+	w.AddUInt64("f", 2)
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	w.AddUInt64("g", 3)
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.End(); err == nil {
+		t.Error("End() on a finished nvlist should fail")
+	}
+
+	r := NVListReader{Data: w.Data}
+	var names []string
+	var walk func() error
+	walk = func() error {
+		for {
+			token, err := r.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			names = append(names, r.Name())
+			if token == TypeNvlist {
+				if err := walk(); err != nil {
+					return err
+				}
+			} else if token == TypeNvlistArray {
+				for range r.NumElements() {
+					if err := walk(); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if err := walk(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"a", "b", "c", "e", "f", "g"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got pairs %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,114 @@
+package nvlist
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func addFuzzSeeds(f *testing.F) {
+	for _, name := range []string{"native-le.nvlist", "native-be.nvlist", "zpool.cache.xdr"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data, false)
+		f.Add(data, true)
+	}
+	data, err := Marshal(map[string]any{
+		"str":     "value",
+		"strs":    []string{"a", "b"},
+		"hrtime":  int64(1),
+		"double":  1.5,
+		"nested":  map[string]any{"a": uint64(1)},
+		"array":   []map[string]any{{"b": int32(2)}, {}},
+		"bools":   []bool{true, false},
+		"bytes":   []byte{1, 2, 3},
+		"boolean": true,
+	})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data, false)
+	f.Add(data, true)
+}
+
+// readValue calls the accessor of the current pair.
+func readValue(r *NVListReader, token NVType) {
+	switch token {
+	case TypeByte, TypeUint8:
+		r.UInt8()
+	case TypeInt8:
+		r.Int8()
+	case TypeInt16, TypeUint16:
+		r.UInt16()
+	case TypeInt32, TypeUint32:
+		r.UInt32()
+	case TypeInt64, TypeUint64, TypeHrtime, TypeDouble:
+		r.UInt64()
+	case TypeBooleanValue:
+		r.Boolean()
+	case TypeString:
+		r.String()
+	case TypeByteArray:
+		r.ByteArray()
+	case TypeInt8Array, TypeUint8Array:
+		r.UInt8Array()
+	case TypeInt16Array, TypeUint16Array:
+		r.UInt16Array()
+	case TypeInt32Array, TypeUint32Array:
+		r.UInt32Array()
+	case TypeInt64Array, TypeUint64Array:
+		for range r.UInt64Array() {
+		}
+	case TypeBooleanArray:
+		r.BooleanArray(nil)
+	case TypeStringArray:
+		r.StringArray(nil)
+	}
+}
+
+func FuzzNext(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte, validate bool) {
+		r := NVListReader{Data: data, Validate: validate}
+		// Every pair has a size of at least 4 bytes, which bounds the number of calls to Next.
+		for range len(data)/4 + 1 {
+			token, err := r.Next()
+			if err == io.EOF {
+				if len(r.frames) == 0 {
+					return
+				}
+				continue
+			}
+			if err != nil {
+				return
+			}
+			readValue(&r, token)
+		}
+	})
+}
+
+func FuzzSkip(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte, validate bool) {
+		r := NVListReader{Data: data, Validate: validate}
+		r.Skip()
+	})
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	addFuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte, validate bool) {
+		r := NVListReader{Data: data, Validate: validate}
+		out := map[string]any{}
+		if err := r.Unmarshal(reflect.ValueOf(&out)); err != nil {
+			return
+		}
+
+		var config map[string]fixtureConfig
+		Unmarshal(data, &config)
+	})
+}
@@ -0,0 +1,21 @@
+//go:build linux || freebsd
+
+package nvlist
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// HrtimeToTime converts a high-resolution timestamp of the running system into wall clock time by relating
+// it to the current reading of the clock it is based on. Converting hrtimes from other systems or previous
+// boots (for example read from disk) results in meaningless times.
+func HrtimeToTime(hrtime time.Duration) time.Time {
+	now := time.Now()
+	var ts unix.Timespec
+	if err := unix.ClockGettime(hrtimeClock, &ts); err != nil {
+		return time.Time{}
+	}
+	return now.Add(hrtime - time.Duration(ts.Nano()))
+}
@@ -0,0 +1,6 @@
+package nvlist
+
+import "golang.org/x/sys/unix"
+
+// hrtimeClock is the clock gethrtime() is based on (nanouptime on FreeBSD).
+const hrtimeClock = unix.CLOCK_MONOTONIC
@@ -0,0 +1,11 @@
+//go:build !linux && !freebsd
+
+package nvlist
+
+import "time"
+
+// HrtimeToTime converts a high-resolution timestamp into wall clock time. ZFS is only supported on Linux and
+// FreeBSD, on other systems the clock gethrtime() is based on is not known and the zero time is returned.
+func HrtimeToTime(hrtime time.Duration) time.Time {
+	return time.Time{}
+}
@@ -0,0 +1,232 @@
+package nvlist
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ToJSON converts the nvlist in data to JSON and writes it to w. Every pair is written as an object holding
+// its type and value, e.g. {"type": "uint64array", "value": [1, 2]}, so that no type information is lost.
+// Embedded nvlists become objects of their pairs and nvlist arrays arrays of those. If indent is not empty,
+// the output is pretty-printed with it.
+func ToJSON(w io.Writer, data []byte, indent string) error {
+	r := NVListReader{Data: data}
+	return r.WriteJSON(w, indent)
+}
+
+// WriteJSON writes the remaining pairs of the current nvlist to w as a JSON object, see ToJSON.
+func (r *NVListReader) WriteJSON(w io.Writer, indent string) error {
+	jw := jsonWriter{w: bufio.NewWriter(w), indent: indent}
+	if err := jw.nvlist(r); err != nil {
+		return err
+	}
+	jw.w.WriteByte('\n')
+	return jw.w.Flush()
+}
+
+// jsonWriter streams JSON to w. Write errors are sticky in bufio.Writer and returned by Flush.
+type jsonWriter struct {
+	w      *bufio.Writer
+	indent string
+	depth  int
+}
+
+func (jw *jsonWriter) newline() {
+	if jw.indent != "" {
+		jw.w.WriteByte('\n')
+		jw.w.WriteString(strings.Repeat(jw.indent, jw.depth))
+	}
+}
+
+func (jw *jsonWriter) key(name string) {
+	jw.string(name)
+	jw.w.WriteByte(':')
+	if jw.indent != "" {
+		jw.w.WriteByte(' ')
+	}
+}
+
+func (jw *jsonWriter) separator() {
+	jw.w.WriteByte(',')
+	if jw.indent != "" {
+		jw.w.WriteByte(' ')
+	}
+}
+
+func (jw *jsonWriter) string(s string) {
+	// Marshalling a string cannot fail, invalid UTF-8 is replaced.
+	b, _ := json.Marshal(s)
+	jw.w.Write(b)
+}
+
+// nvlist writes the remaining pairs of the current nvlist as object.
+func (jw *jsonWriter) nvlist(r *NVListReader) error {
+	jw.w.WriteByte('{')
+	jw.depth++
+	empty := true
+	for {
+		token, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if !empty {
+			jw.w.WriteByte(',')
+		}
+		empty = false
+		jw.newline()
+		jw.key(r.Name())
+		if err := jw.pair(r, token); err != nil {
+			return err
+		}
+	}
+	jw.depth--
+	if !empty {
+		jw.newline()
+	}
+	jw.w.WriteByte('}')
+	return nil
+}
+
+// pair writes the type and value of the current pair.
+func (jw *jsonWriter) pair(r *NVListReader, token NVType) error {
+	jw.w.WriteByte('{')
+	jw.depth++
+	jw.newline()
+	jw.key("type")
+	jw.string(token.String())
+	// Boolean pairs are flags that are true by being present and have no value.
+	if token != TypeBoolean {
+		jw.w.WriteByte(',')
+		jw.newline()
+		jw.key("value")
+		if err := jw.value(r, token); err != nil {
+			return err
+		}
+	}
+	jw.depth--
+	jw.newline()
+	jw.w.WriteByte('}')
+	return nil
+}
+
+func (jw *jsonWriter) value(r *NVListReader, token NVType) error {
+	switch token {
+	case TypeByte, TypeUint8:
+		jw.uint(uint64(r.UInt8()))
+	case TypeInt8:
+		jw.int(int64(r.Int8()))
+	case TypeInt16:
+		jw.int(int64(r.Int16()))
+	case TypeUint16:
+		jw.uint(uint64(r.UInt16()))
+	case TypeInt32:
+		jw.int(int64(r.Int32()))
+	case TypeUint32:
+		jw.uint(uint64(r.UInt32()))
+	case TypeInt64, TypeHrtime:
+		jw.int(r.Int64())
+	case TypeUint64:
+		jw.uint(r.UInt64())
+	case TypeDouble:
+		jw.float(r.Double())
+	case TypeBooleanValue:
+		b, err := r.Boolean()
+		if err != nil {
+			return err
+		}
+		jw.w.WriteString(strconv.FormatBool(b))
+	case TypeString:
+		s, err := r.String()
+		if err != nil {
+			return err
+		}
+		jw.string(s)
+	case TypeByteArray, TypeUint8Array:
+		writeJSONArray(jw, r.UInt8Array(), func(v uint8) { jw.uint(uint64(v)) })
+	case TypeInt8Array:
+		writeJSONArray(jw, r.Int8Array(), func(v int8) { jw.int(int64(v)) })
+	case TypeInt16Array:
+		writeJSONArray(jw, r.Int16Array(), func(v int16) { jw.int(int64(v)) })
+	case TypeUint16Array:
+		writeJSONArray(jw, r.UInt16Array(), func(v uint16) { jw.uint(uint64(v)) })
+	case TypeInt32Array:
+		writeJSONArray(jw, r.Int32Array(), func(v int32) { jw.int(int64(v)) })
+	case TypeUint32Array:
+		writeJSONArray(jw, r.UInt32Array(), func(v uint32) { jw.uint(uint64(v)) })
+	case TypeInt64Array:
+		writeJSONArray(jw, r.Int64Array(), jw.int)
+	case TypeUint64Array:
+		writeJSONArray(jw, r.UInt64Array(), jw.uint)
+	case TypeBooleanArray:
+		val, err := r.BooleanArray(nil)
+		if err != nil {
+			return err
+		}
+		writeJSONArray(jw, val, func(v bool) { jw.w.WriteString(strconv.FormatBool(v)) })
+	case TypeStringArray:
+		val, err := r.StringArray(nil)
+		if err != nil {
+			return err
+		}
+		writeJSONArray(jw, val, jw.string)
+	case TypeNvlist:
+		return jw.nvlist(r)
+	case TypeNvlistArray:
+		jw.w.WriteByte('[')
+		jw.depth++
+		for i := range r.NumElements() {
+			if i > 0 {
+				jw.w.WriteByte(',')
+			}
+			jw.newline()
+			if err := jw.nvlist(r); err != nil {
+				return err
+			}
+		}
+		jw.depth--
+		if r.NumElements() > 0 {
+			jw.newline()
+		}
+		jw.w.WriteByte(']')
+	default:
+		return fmt.Errorf("%w: %d", ErrUnsupportedType, uint32(token))
+	}
+	return nil
+}
+
+func (jw *jsonWriter) int(v int64) {
+	jw.w.WriteString(strconv.FormatInt(v, 10))
+}
+
+func (jw *jsonWriter) uint(v uint64) {
+	jw.w.WriteString(strconv.FormatUint(v, 10))
+}
+
+// float writes v as number, or as string if it is NaN or infinite, which JSON cannot represent.
+func (jw *jsonWriter) float(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		jw.string(strconv.FormatFloat(v, 'g', -1, 64))
+		return
+	}
+	jw.w.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+// writeJSONArray writes the elements of an array of values on a single line.
+func writeJSONArray[T any](jw *jsonWriter, vals []T, write func(T)) {
+	jw.w.WriteByte('[')
+	for i, v := range vals {
+		if i > 0 {
+			jw.separator()
+		}
+		write(v)
+	}
+	jw.w.WriteByte(']')
+}
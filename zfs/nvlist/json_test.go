@@ -0,0 +1,74 @@
+package nvlist
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestToJSON(t *testing.T) {
+	w := NVListWriter{}
+	w.AddUInt64("version", 5000)
+	w.AddBoolean("flag")
+	w.AddString("name", "tank \"1\"")
+	w.AddUInt64Array("stats", []uint64{1, 2})
+	w.AddDouble("ratio", 1.5)
+	w.BeginNvlist("tree")
+	w.AddInt32("id", -1)
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	w.BeginNvlistArray("children", 2)
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	w.AddBooleanValue("b", true)
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := ToJSON(&buf, w.Data, ""); err != nil {
+		t.Fatalf("ToJSON() failed: %v", err)
+	}
+	want := `{"version":{"type":"uint64","value":5000},"flag":{"type":"boolean"},` +
+		`"name":{"type":"string","value":"tank \"1\""},"stats":{"type":"uint64array","value":[1,2]},` +
+		`"ratio":{"type":"double","value":1.5},"tree":{"type":"nvlist","value":{"id":{"type":"int32","value":-1}}},` +
+		`"children":{"type":"nvlistarray","value":[{},{"b":{"type":"booleanvalue","value":true}}]}}` + "\n"
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestToJSONIndent(t *testing.T) {
+	for _, name := range []string{"native-le.nvlist", "native-be.nvlist", "zpool.cache.xdr"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var compact, indented bytes.Buffer
+			if err := ToJSON(&compact, data, ""); err != nil {
+				t.Fatalf("ToJSON() failed: %v", err)
+			}
+			if err := ToJSON(&indented, data, "  "); err != nil {
+				t.Fatalf("ToJSON() failed: %v", err)
+			}
+
+			// The indented output has to be the same JSON as the compact output.
+			var got bytes.Buffer
+			if err := json.Compact(&got, indented.Bytes()); err != nil {
+				t.Fatalf("ToJSON() produced invalid JSON: %v", err)
+			}
+			if !bytes.Equal(got.Bytes(), bytes.TrimSpace(compact.Bytes())) {
+				t.Errorf("indented output mismatch:\ngot:\n%s\nwant:\n%s", got.String(), compact.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package nvlist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrKeyNotFound  = errors.New("key not found")
+	ErrTypeMismatch = errors.New("type mismatch")
+)
+
+// KeyNotFoundError is returned by Lookup if a pair or nvlist array element does not exist.
+type KeyNotFoundError struct {
+	Path string
+}
+
+func (e *KeyNotFoundError) Error() string {
+	return fmt.Sprintf("key %q not found", e.Path)
+}
+
+func (e *KeyNotFoundError) Is(target error) bool {
+	return target == ErrKeyNotFound
+}
+
+// TypeMismatchError is returned by Lookup if a pair on the path cannot be descended into, for example an
+// index into a pair that is not an nvlist array.
+type TypeMismatchError struct {
+	Path     string
+	Type     NVType
+	Expected NVType
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("%q is a %v, expected %v", e.Path, e.Type, e.Expected)
+}
+
+func (e *TypeMismatchError) Is(target error) bool {
+	return target == ErrTypeMismatch
+}
+
+// pathElem is either the name of a pair or, if index is not negative, an index into an nvlist array.
+type pathElem struct {
+	name  string
+	index int
+}
+
+// Path is a compiled lookup path, see NewPath.
+type Path []pathElem
+
+// NewPath compiles a path for LookupPath. Every element is either a string naming a pair or an int indexing an
+// nvlist array.
+func NewPath(elems ...any) (Path, error) {
+	p := make(Path, len(elems))
+	for i, e := range elems {
+		var err error
+		if p[i], err = toPathElem(e); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p Path) String() string {
+	return formatPath(p, len(p), identityPathElem)
+}
+
+func toPathElem(e any) (pathElem, error) {
+	switch e := e.(type) {
+	case string:
+		return pathElem{name: e, index: -1}, nil
+	case int:
+		if e < 0 {
+			return pathElem{}, fmt.Errorf("invalid path index %d", e)
+		}
+		return pathElem{index: e}, nil
+	default:
+		return pathElem{}, fmt.Errorf("invalid path element of type %T", e)
+	}
+}
+
+func identityPathElem(e pathElem) (pathElem, error) {
+	return e, nil
+}
+
+// formatPath formats the first n elements of a path, e.g. "vdev_tree.children[0].vdev_stats".
+func formatPath[E any](elems []E, n int, conv func(E) (pathElem, error)) string {
+	var b strings.Builder
+	for _, e := range elems[:n] {
+		pe, _ := conv(e)
+		if pe.index >= 0 {
+			b.WriteString("[" + strconv.Itoa(pe.index) + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(pe.name)
+	}
+	return b.String()
+}
+
+// Lookup moves to the pair at the given path within the remaining pairs of the current nvlist and returns
+// its type, so that its value can be read with the accessors. Every element of the path is either a string
+// naming a pair or an int indexing an nvlist array, e.g. r.Lookup("vdev_tree", "children", 0, "vdev_stats").
+// If the path ends with an nvlist array element, TypeNvlist is returned and Next returns the pairs of that
+// element.
+//
+// Pairs that come before the ones on the path are skipped, including their embedded nvlists. Use Depth and
+// Unwind to continue after the nvlists that Lookup descended into.
+func (r *NVListReader) Lookup(elems ...any) (NVType, error) {
+	return lookup(r, elems, toPathElem)
+}
+
+// LookupPath is like Lookup with a compiled path.
+func (r *NVListReader) LookupPath(p Path) (NVType, error) {
+	return lookup(r, p, identityPathElem)
+}
+
+func lookup[E any](r *NVListReader, elems []E, conv func(E) (pathElem, error)) (NVType, error) {
+	token := TypeNvlist
+	for i, e := range elems {
+		elem, err := conv(e)
+		if err != nil {
+			return TypeUnknown, err
+		}
+
+		if elem.index >= 0 {
+			if token != TypeNvlistArray {
+				return TypeUnknown, &TypeMismatchError{Path: formatPath(elems, i, conv), Type: token, Expected: TypeNvlistArray}
+			}
+			if elem.index >= r.NumElements() {
+				return TypeUnknown, &KeyNotFoundError{Path: formatPath(elems, i+1, conv)}
+			}
+			for range elem.index {
+				if err := r.Skip(); err != nil {
+					return TypeUnknown, err
+				}
+			}
+			token = TypeNvlist
+			continue
+		}
+
+		if token != TypeNvlist {
+			return TypeUnknown, &TypeMismatchError{Path: formatPath(elems, i, conv), Type: token, Expected: TypeNvlist}
+		}
+		for {
+			token, err = r.Next()
+			if err == io.EOF {
+				return TypeUnknown, &KeyNotFoundError{Path: formatPath(elems, i+1, conv)}
+			}
+			if err != nil {
+				return TypeUnknown, err
+			}
+			if r.Name() == elem.name {
+				break
+			}
+			if err := r.SkipValue(token); err != nil {
+				return TypeUnknown, err
+			}
+		}
+	}
+	return token, nil
+}
+
+// SkipValue skips the embedded nvlist(s) of the current pair, if it has any.
+func (r *NVListReader) SkipValue(token NVType) error {
+	switch token {
+	case TypeNvlist:
+		return r.Skip()
+	case TypeNvlistArray:
+		for range r.NumElements() {
+			if err := r.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Depth returns the number of embedded nvlists the reader is currently in.
+func (r *NVListReader) Depth() int {
+	return len(r.frames)
+}
+
+// Unwind skips the remaining pairs of all embedded nvlists deeper than depth, so that Next continues with
+// the pair following them. Together with Depth it allows to continue reading after a Lookup.
+func (r *NVListReader) Unwind(depth int) error {
+	for len(r.frames) > depth {
+		if _, err := r.Next(); err != nil && err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
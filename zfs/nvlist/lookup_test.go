@@ -0,0 +1,91 @@
+package nvlist
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	for _, name := range []string{"native-le.nvlist", "native-be.nvlist", "zpool.cache.xdr"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := NVListReader{Data: data}
+			token, err := r.Lookup("tank", "vdev_tree", "children", 0, "children", 1, "path")
+			if err != nil {
+				t.Fatalf("Lookup() failed: %v", err)
+			}
+			if s, _ := r.String(); token != TypeString || s != "/dev/sdb1" {
+				t.Errorf("got %v %q, want string %q", token, s, "/dev/sdb1")
+			}
+
+			// Continue after the vdev tree with the following pairs of the pool.
+			if err := r.Unwind(1); err != nil {
+				t.Fatalf("Unwind() failed: %v", err)
+			}
+			token, err = r.Lookup("misc", "uint64s")
+			if err != nil {
+				t.Fatalf("Lookup() after Unwind() failed: %v", err)
+			}
+			if got := r.UInt64Array(); token != TypeUint64Array || len(got) != 2 || got[1] != 14 {
+				t.Errorf("got %v %v, want uint64array [13 14]", token, got)
+			}
+		})
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "zpool.cache.xdr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   []any
+		target error
+		want   string
+	}{
+		{[]any{"tank", "missing"}, ErrKeyNotFound, `key "tank.missing" not found`},
+		{[]any{"tank", "vdev_tree", "children", 2}, ErrKeyNotFound, `key "tank.vdev_tree.children[2]" not found`},
+		{[]any{"tank", "name", "x"}, ErrTypeMismatch, `"tank.name" is a string, expected nvlist`},
+		{[]any{"tank", "vdev_tree", 0}, ErrTypeMismatch, `"tank.vdev_tree" is a nvlist, expected nvlistarray`},
+	}
+	for _, test := range tests {
+		r := NVListReader{Data: data}
+		_, err := r.Lookup(test.path...)
+		if !errors.Is(err, test.target) || err.Error() != test.want {
+			t.Errorf("Lookup(%v) returned %v, want %v", test.path, err, test.want)
+		}
+	}
+}
+
+func TestLookupPathAllocs(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "native-le.nvlist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, err := NewPath("tank", "vdev_tree", "children", 1, "path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := path.String(), "tank.vdev_tree.children[1].path"; got != want {
+		t.Errorf("got path %q, want %q", got, want)
+	}
+
+	r := NVListReader{Data: data, frames: make([]listFrame, 0, DefaultMaxDepth)}
+	allocs := testing.AllocsPerRun(100, func() {
+		r.pos = 0
+		r.frames = r.frames[:0]
+		if _, err := r.LookupPath(path); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("LookupPath() allocated %v times", allocs)
+	}
+}
@@ -0,0 +1,333 @@
+// Nvlistgen generates reflection-free nvlist decoders for structs with "nvlist" tags. For every type T it
+// emits a method
+//
+//	func (t *T) decodeNvlist(r *nvlist.NVListReader) error
+//
+// (DecodeNvlist for exported types) that decodes the remaining pairs of the current nvlist into t in the same
+// way as NVListReader.Unmarshal, but without reflection and without allocating unless the struct holds
+// strings, slices or pointers. Pairs of a wrong type result in "invalid type for <name>" errors.
+//
+// Tags name a pair and may address pairs of embedded nvlists with dots, e.g. `nvlist:"used.value"` decodes
+// the "value" pair of the embedded nvlist "used". Embedded nvlists and nvlist arrays can be decoded into
+// (pointers to and slices of) structs that are generated as well. Unlike Unmarshal, unexported fields are
+// decoded if they are tagged.
+//
+// Usage:
+//
+//	//go:generate go run ./zfs/nvlist/nvlistgen -type datasetProps,vdev -output nvlist_decoders.go
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const nvlistImport = "github.com/ReneHollander/prometheus-zfs-exporter/zfs/nvlist"
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of type names to generate decoders for")
+	output    = flag.String("output", "", "output file name, defaults to <first type>_nvlist.go")
+)
+
+// scalar describes how a Go type is decoded from a pair that is not an nvlist.
+type scalar struct {
+	nvType   string
+	accessor string
+	// fallible accessors return an error as second value.
+	fallible bool
+	// wrap is applied to the accessor expression, e.g. to copy memory owned by the reader.
+	wrap string
+}
+
+var scalars = map[string]scalar{
+	"uint64":        {nvType: "TypeUint64", accessor: "UInt64()"},
+	"int64":         {nvType: "TypeInt64", accessor: "Int64()"},
+	"uint32":        {nvType: "TypeUint32", accessor: "UInt32()"},
+	"int32":         {nvType: "TypeInt32", accessor: "Int32()"},
+	"uint16":        {nvType: "TypeUint16", accessor: "UInt16()"},
+	"int16":         {nvType: "TypeInt16", accessor: "Int16()"},
+	"uint8":         {nvType: "TypeUint8", accessor: "UInt8()"},
+	"int8":          {nvType: "TypeInt8", accessor: "Int8()"},
+	"byte":          {nvType: "TypeByte", accessor: "Byte()"},
+	"float64":       {nvType: "TypeDouble", accessor: "Double()"},
+	"time.Duration": {nvType: "TypeHrtime", accessor: "Hrtime()"},
+	"string":        {nvType: "TypeString", accessor: "String()", fallible: true, wrap: "strings.Clone"},
+	"[]uint64":      {nvType: "TypeUint64Array", accessor: "UInt64Array()", wrap: "slices.Clone"},
+	"[]int64":       {nvType: "TypeInt64Array", accessor: "Int64Array()", wrap: "slices.Clone"},
+	"[]uint32":      {nvType: "TypeUint32Array", accessor: "UInt32Array()", wrap: "slices.Clone"},
+	"[]int32":       {nvType: "TypeInt32Array", accessor: "Int32Array()", wrap: "slices.Clone"},
+	"[]uint16":      {nvType: "TypeUint16Array", accessor: "UInt16Array()", wrap: "slices.Clone"},
+	"[]int16":       {nvType: "TypeInt16Array", accessor: "Int16Array()", wrap: "slices.Clone"},
+	"[]uint8":       {nvType: "TypeUint8Array", accessor: "UInt8Array()", wrap: "slices.Clone"},
+	"[]int8":        {nvType: "TypeInt8Array", accessor: "Int8Array()", wrap: "slices.Clone"},
+	"[]byte":        {nvType: "TypeByteArray", accessor: "ByteArray()", wrap: "slices.Clone"},
+	"[]string":      {nvType: "TypeStringArray", accessor: "StringArraySafe(nil)", fallible: true},
+	"[]bool":        {nvType: "TypeBooleanArray", accessor: "BooleanArray(nil)", fallible: true},
+}
+
+// field is a struct field that is decoded from the pair at path.
+type field struct {
+	goName string
+	goType string
+	path   []string
+}
+
+// node is an nvlist in the tree of pairs a struct is decoded from. Leaves carry the field they are decoded into.
+type node struct {
+	name     string
+	children []*node
+	field    *field
+}
+
+func (n *node) child(name string) *node {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &node{name: name}
+	n.children = append(n.children, c)
+	return c
+}
+
+type generator struct {
+	buf   bytes.Buffer
+	types map[string]*ast.StructType
+}
+
+func (g *generator) printf(format string, args ...any) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("nvlistgen: ")
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	names := strings.Split(*typeNames, ",")
+	if *output == "" {
+		*output = strings.ToLower(names[0]) + "_nvlist.go"
+	}
+
+	src, err := generate(".", names, filepath.Base(*output))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*output, src, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generate returns the decoders for the named types of the package in dir. The file named output is ignored
+// when parsing the package, so that a stale version of it cannot break the generation.
+func generate(dir string, names []string, output string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != output
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected a single package in %s, found %d", dir, len(pkgs))
+	}
+
+	g := generator{types: make(map[string]*ast.StructType)}
+	var pkgName string
+	for name, pkg := range pkgs {
+		pkgName = name
+		for _, file := range pkg.Files {
+			ast.Inspect(file, func(n ast.Node) bool {
+				if spec, ok := n.(*ast.TypeSpec); ok && slices.Contains(names, spec.Name.Name) {
+					if st, ok := spec.Type.(*ast.StructType); ok {
+						g.types[spec.Name.Name] = st
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	for _, name := range names {
+		st, ok := g.types[name]
+		if !ok {
+			return nil, fmt.Errorf("struct type %s not found", name)
+		}
+		if err := g.generateType(name, st); err != nil {
+			return nil, fmt.Errorf("error generating decoder for %s: %w", name, err)
+		}
+	}
+
+	body := g.buf.String()
+	var header bytes.Buffer
+	fmt.Fprintf(&header, "// Code generated by nvlistgen; DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkgName)
+	fmt.Fprintf(&header, "\t\"fmt\"\n\t\"io\"\n")
+	if strings.Contains(body, "slices.") {
+		fmt.Fprintf(&header, "\t\"slices\"\n")
+	}
+	if strings.Contains(body, "strings.") {
+		fmt.Fprintf(&header, "\t\"strings\"\n")
+	}
+	fmt.Fprintf(&header, "\n\t%q\n)\n", nvlistImport)
+
+	src, err := format.Source(append(header.Bytes(), body...))
+	if err != nil {
+		return nil, fmt.Errorf("error formatting generated code: %w", err)
+	}
+	return src, nil
+}
+
+func methodName(typeName string) string {
+	if ast.IsExported(typeName) {
+		return "DecodeNvlist"
+	}
+	return "decodeNvlist"
+}
+
+func (g *generator) generateType(name string, st *ast.StructType) error {
+	root := &node{}
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return fmt.Errorf("embedded fields are not supported")
+		}
+		goType := types.ExprString(f.Type)
+		var tag string
+		if f.Tag != nil {
+			unquoted, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return err
+			}
+			tag = reflect.StructTag(unquoted).Get("nvlist")
+		}
+		for _, ident := range f.Names {
+			if tag == "-" || (tag == "" && !ident.IsExported()) {
+				continue
+			}
+			pairName, _, _ := strings.Cut(tag, ",")
+			if pairName == "" {
+				pairName = ident.Name
+			}
+			path := strings.Split(pairName, ".")
+
+			n := root
+			for _, elem := range path {
+				if n.field != nil {
+					return fmt.Errorf("field %s: %q is decoded into %s already", ident.Name, n.name, n.field.goName)
+				}
+				n = n.child(elem)
+			}
+			if n.field != nil || len(n.children) > 0 {
+				return fmt.Errorf("field %s: pair %q is decoded into another field already", ident.Name, pairName)
+			}
+			n.field = &field{goName: ident.Name, goType: goType, path: path}
+		}
+	}
+
+	recv := string(unicode.ToLower([]rune(name)[0]))
+	if recv == "r" || recv == "i" {
+		// Avoid shadowing the reader and loop variables of the generated code.
+		recv = "t"
+	}
+	g.printf("\n// %s decodes the remaining pairs of the current nvlist into %s.\n", methodName(name), recv)
+	g.printf("func (%s *%s) %s(r *nvlist.NVListReader) error {\n", recv, name, methodName(name))
+	if err := g.generateList(recv, root); err != nil {
+		return err
+	}
+	g.printf("return nil\n}\n")
+	return nil
+}
+
+// generateList emits a loop over the pairs of the nvlist n, breaking out of it at the end of the list.
+func (g *generator) generateList(recv string, n *node) error {
+	g.printf("for {\ntoken, err := r.Next()\nif err == io.EOF {\nbreak\n} else if err != nil {\nreturn err\n}\n")
+	g.printf("switch r.Name() {\n")
+	for _, c := range n.children {
+		g.printf("case %q:\n", c.name)
+		if c.field != nil {
+			if err := g.generateField(recv, c.field); err != nil {
+				return err
+			}
+			continue
+		}
+		g.printf("if token != nvlist.TypeNvlist {\nreturn fmt.Errorf(\"invalid type for %s\")\n}\n", firstName(c))
+		if err := g.generateList(recv, c); err != nil {
+			return err
+		}
+	}
+	g.printf("default:\nif err := r.SkipValue(token); err != nil {\nreturn err\n}\n}\n}\n")
+	return nil
+}
+
+// firstName returns the name of the top-level pair of the first field below n, which is used in errors.
+func firstName(n *node) string {
+	for n.field == nil {
+		n = n.children[0]
+	}
+	return n.field.path[0]
+}
+
+func (g *generator) generateField(recv string, f *field) error {
+	target := recv + "." + f.goName
+	invalid := fmt.Sprintf("return fmt.Errorf(\"invalid type for %s\")\n", f.path[0])
+
+	if s, ok := scalars[f.goType]; ok {
+		g.printf("if token != nvlist.%s {\n%s}\n", s.nvType, invalid)
+		value := "r." + s.accessor
+		if s.fallible {
+			g.printf("val, err := %s\nif err != nil {\nreturn err\n}\n", value)
+			value = "val"
+		}
+		if s.wrap != "" {
+			value = s.wrap + "(" + value + ")"
+		}
+		g.printf("%s = %s\n", target, value)
+		return nil
+	}
+
+	switch t := f.goType; {
+	case t == "bool":
+		// Booleans may be stored as value or as flag that is true by being present.
+		g.printf("switch token {\ncase nvlist.TypeBoolean:\n%s = true\n", target)
+		g.printf("case nvlist.TypeBooleanValue:\nval, err := r.Boolean()\nif err != nil {\nreturn err\n}\n%s = val\n", target)
+		g.printf("default:\n%s}\n", invalid)
+	case g.types[t] != nil:
+		g.printf("if token != nvlist.TypeNvlist {\n%s}\n", invalid)
+		g.printf("if err := %s.%s(r); err != nil {\nreturn err\n}\n", target, methodName(t))
+	case strings.HasPrefix(t, "*") && g.types[t[1:]] != nil:
+		g.printf("if token != nvlist.TypeNvlist {\n%s}\n", invalid)
+		g.printf("%s = &%s{}\n", target, t[1:])
+		g.printf("if err := %s.%s(r); err != nil {\nreturn err\n}\n", target, methodName(t[1:]))
+	case strings.HasPrefix(t, "[]"):
+		elem := t[2:]
+		structName := strings.TrimPrefix(elem, "*")
+		if g.types[structName] == nil {
+			return fmt.Errorf("field %s: unsupported type %s", f.goName, t)
+		}
+		g.printf("if token != nvlist.TypeNvlistArray {\n%s}\n", invalid)
+		g.printf("%s = make(%s, r.NumElements())\nfor i := range %s {\n", target, t, target)
+		if elem != structName {
+			g.printf("%s[i] = &%s{}\n", target, structName)
+		}
+		g.printf("if err := %s[i].%s(r); err != nil {\nreturn err\n}\n}\n", target, methodName(structName))
+	default:
+		return fmt.Errorf("field %s: unsupported type %s", f.goName, t)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGeneratedUpToDate makes sure that the generated decoders of the ioctl package match the current
+// generator and structs.
+func TestGeneratedUpToDate(t *testing.T) {
+	dir := filepath.Join("..", "..", "ioctl")
+	want, err := os.ReadFile(filepath.Join(dir, "nvlist_decoders.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := generate(dir, []string{"PoolConfig", "PoolStats", "Vdev", "VdevStatsEx", "PoolProps", "PoolPropUint64", "PoolPropString", "DatasetProps"}, "nvlist_decoders.go")
+	if err != nil {
+		t.Fatalf("generate() failed: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("nvlist_decoders.go is out of date, run go generate")
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"type t struct { A map[string]int `nvlist:\"a\"` }", "unsupported type map"},
+		{"type t struct { A []other `nvlist:\"a\"` }", "unsupported type []other"},
+		{"type t struct { A, B uint64 `nvlist:\"a\"` }", "decoded into another field"},
+		{"type t struct { A uint64 `nvlist:\"a\"`; B uint64 `nvlist:\"a.b\"` }", "decoded into A already"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "t.go"), []byte("package p\n"+test.src+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := generate(dir, []string{"t"}, "t_nvlist.go")
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("generate(%s) returned %v, want error containing %q", test.src, err, test.want)
+		}
+	}
+}
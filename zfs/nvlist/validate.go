@@ -0,0 +1,231 @@
+package nvlist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// DefaultMaxDepth is the nesting depth of embedded nvlists that is allowed if NVListReader.MaxDepth is not
+// set. It matches the limit of the kernel's nvpair_max_recursion.
+const DefaultMaxDepth = 20
+
+// nvVersion is the only nvlist version (NV_VERSION) there is.
+const nvVersion = 0
+
+var ErrMaxDepth = errors.New("this nvlist exceeds the maximum nesting depth")
+
+// DecodeError describes invalid data found while decoding an nvlist.
+type DecodeError struct {
+	// Offset is the byte offset of the pair the error was found in.
+	Offset int
+	// Path is the path of that pair, such as "tank.vdev_tree.children[1].path".
+	Path string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+	}
+	return fmt.Sprintf("%v at offset %d (%s)", e.Err, e.Offset, e.Path)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError annotates err with the position of the current pair, unless it already is a DecodeError.
+func (r *NVListReader) decodeError(err error) error {
+	var decodeErr *DecodeError
+	if err == io.EOF || errors.As(err, &decodeErr) {
+		return err
+	}
+	return &DecodeError{Offset: r.pairPos, Path: r.path(), Err: err}
+}
+
+// listFrame is an embedded nvlist that is currently being read. remaining counts the elements of an nvlist
+// array that follow the current one.
+type listFrame struct {
+	name      string
+	pairPos   int
+	array     bool
+	index     int
+	remaining int
+}
+
+// path returns the names of the open embedded nvlists and the current pair, separated by dots.
+func (r *NVListReader) path() string {
+	var b strings.Builder
+	for i, frame := range r.frames {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(frame.name)
+		if frame.array {
+			b.WriteString("[" + strconv.Itoa(frame.index) + "]")
+		}
+	}
+	// The current pair is already part of the path if it opened the innermost nvlist.
+	if r.nameBytes != nil && (len(r.frames) == 0 || r.frames[len(r.frames)-1].pairPos != r.pairPos) {
+		if len(r.frames) > 0 {
+			b.WriteByte('.')
+		}
+		b.Write(r.nameBytes)
+	}
+	return b.String()
+}
+
+// pushFrame starts reading the embedded nvlist(s) of the current pair.
+func (r *NVListReader) pushFrame(array bool) error {
+	maxDepth := r.MaxDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	if len(r.frames) >= maxDepth {
+		return ErrMaxDepth
+	}
+	frame := listFrame{name: r.Name(), pairPos: r.pairPos, array: array}
+	if array {
+		frame.remaining = r.numElements - 1
+	}
+	r.frames = append(r.frames, frame)
+	return nil
+}
+
+// endList handles the end of an nvlist and always returns io.EOF unless the data is invalid. If the nvlist
+// is an element of an nvlist array that has more elements, the following calls to Next return the pairs of
+// the next element.
+func (r *NVListReader) endList() error {
+	if len(r.frames) == 0 {
+		return io.EOF
+	}
+	top := len(r.frames) - 1
+	frame := r.frames[top]
+	r.frames = r.frames[:top]
+	if frame.remaining > 0 {
+		if r.encoding == EncodingXDR {
+			// Every element of an XDR nvlist array starts with its own header.
+			if r.pos+xdrNvlistHeaderSize > len(r.Data) {
+				return ErrInvalidData
+			}
+			r.pos += xdrNvlistHeaderSize
+		}
+		frame.index++
+		frame.remaining--
+		r.frames = append(r.frames, frame)
+	}
+	return io.EOF
+}
+
+// minValueSize returns the number of bytes the accessors of type t read for numElements elements, or false if
+// t is not a known type.
+func minValueSize(t NVType, numElements int) (int, bool) {
+	switch t {
+	case TypeBoolean, TypeNvlist, TypeNvlistArray:
+		return 0, true
+	case TypeByte, TypeInt8, TypeUint8:
+		return 1, true
+	case TypeInt16, TypeUint16:
+		return 2, true
+	case TypeInt32, TypeUint32, TypeBooleanValue:
+		return 4, true
+	case TypeInt64, TypeUint64, TypeHrtime, TypeDouble:
+		return 8, true
+	case TypeString:
+		return 1, true
+	case TypeByteArray, TypeInt8Array, TypeUint8Array, TypeStringArray:
+		return numElements, true
+	case TypeInt16Array, TypeUint16Array:
+		return 2 * numElements, true
+	case TypeInt32Array, TypeUint32Array, TypeBooleanArray:
+		return 4 * numElements, true
+	case TypeInt64Array, TypeUint64Array:
+		return 8 * numElements, true
+	default:
+		return 0, false
+	}
+}
+
+// checkValue makes sure that the value of the current pair holds enough data for the accessors of its type,
+// which is always done. In Validate mode the pair also has to have a known type and a matching number of
+// elements and its strings need to be terminated.
+func (r *NVListReader) checkValue(t NVType) error {
+	size, known := minValueSize(t, r.numElements)
+	if len(r.value) < size {
+		return ErrInvalidData
+	}
+	if !r.Validate {
+		return nil
+	}
+	if !known {
+		return fmt.Errorf("%w: %d", ErrUnsupportedType, uint32(t))
+	}
+
+	switch t {
+	case TypeBoolean:
+		if r.numElements != 0 {
+			return ErrInvalidData
+		}
+	case TypeByteArray, TypeInt8Array, TypeUint8Array, TypeInt16Array, TypeUint16Array, TypeInt32Array,
+		TypeUint32Array, TypeInt64Array, TypeUint64Array, TypeBooleanArray, TypeStringArray, TypeNvlistArray:
+	default:
+		if r.numElements != 1 {
+			return ErrInvalidData
+		}
+	}
+
+	switch t {
+	case TypeString:
+		if i := bytes.IndexByte(r.value, 0x00); i < 0 {
+			return fmt.Errorf("%w: unterminated string", ErrInvalidData)
+		} else if r.encoding == EncodingXDR && i != len(r.value)-1 {
+			return fmt.Errorf("%w: string contains NUL", ErrInvalidData)
+		}
+	case TypeStringArray:
+		if bytes.Count(r.value, []byte{0x00}) < r.numElements {
+			return fmt.Errorf("%w: unterminated string", ErrInvalidData)
+		}
+	}
+	return nil
+}
+
+// nativeValueSize returns the size of the value of the current pair in native encoding as libnvpair computes
+// it, before padding.
+func (r *NVListReader) nativeValueSize(t NVType) int {
+	switch t {
+	case TypeString:
+		return bytes.IndexByte(r.value, 0x00) + 1
+	case TypeStringArray:
+		// r.value starts after the pointers, which need to be accounted for.
+		size, offset := 8*r.numElements, 0
+		for range r.numElements {
+			i := bytes.IndexByte(r.value[offset:], 0x00)
+			offset += i + 1
+		}
+		return size + offset
+	case TypeNvlist:
+		return nvlistSize
+	case TypeNvlistArray:
+		return (pointerSize + nvlistSize) * r.numElements
+	default:
+		size, _ := minValueSize(t, r.numElements)
+		return size
+	}
+}
+
+// alignedValue returns a pointer to the current value that is aligned to size bytes. Values in native
+// encoding are only aligned relative to the start of their nvlist, so they are copied if Data itself is not
+// aligned suitably.
+func (r *NVListReader) alignedValue(size int) unsafe.Pointer {
+	if uintptr(unsafe.Pointer(unsafe.SliceData(r.value)))%uintptr(size) != 0 {
+		aligned := alignedBuffer(len(r.value))
+		copy(aligned, r.value)
+		r.value = aligned
+	}
+	return unsafe.Pointer(unsafe.SliceData(r.value))
+}
@@ -0,0 +1,331 @@
+package nvlist
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+// xdrNvlistHeaderSize is the size of the version and nvflag fields in front of every embedded XDR nvlist.
+const xdrNvlistHeaderSize = 8
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// alignedBuffer allocates a byte slice of length n that is suitably aligned to be reinterpreted as a slice
+// of any of the nvlist value types.
+func alignedBuffer(n int) []byte {
+	if n == 0 {
+		return []byte{}
+	}
+	buf := make([]uint64, (n+7)/8)
+	return unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(buf))), n)
+}
+
+// nextXDR is the XDR counterpart of Next. In XDR every pair starts with its encoded and decoded size,
+// followed by the name as XDR string, the type and the number of elements. Embedded nvlists are stored
+// inline as part of their pair and every nvlist is terminated by two zero sizes.
+func (r *NVListReader) nextXDR() (NVType, error) {
+	startPos := r.pos
+
+	encodedSize, err := r.readInt32()
+	if err != nil {
+		return TypeUnknown, err
+	}
+	decodedSize, err := r.readInt32()
+	if err != nil {
+		return TypeUnknown, err
+	}
+	if encodedSize == 0 && decodedSize == 0 {
+		return TypeUnknown, r.endList()
+	}
+	if encodedSize < 0 || decodedSize < 0 {
+		return TypeUnknown, ErrInvalidData
+	}
+	if r.Validate && encodedSize%int32(r.alignment) != 0 {
+		return TypeUnknown, fmt.Errorf("%w: unaligned pair size %d", ErrInvalidData, encodedSize)
+	}
+	nextNVPairPos := startPos + int(encodedSize)
+	if nextNVPairPos > len(r.Data) {
+		return TypeUnknown, ErrInvalidData
+	}
+
+	nameSize, err := r.readUint32()
+	if err != nil {
+		return TypeUnknown, err
+	}
+	if nameSize > uint32(nextNVPairPos-r.pos) {
+		return TypeUnknown, ErrInvalidData
+	}
+	nameBytes, err := r.readBytes(int(nameSize))
+	if err != nil {
+		return TypeUnknown, err
+	}
+	r.nameBytes = nameBytes
+	r.skipN(align4(int(nameSize)) - int(nameSize))
+
+	nvTypeUInt32, err := r.readUint32()
+	if err != nil {
+		return TypeUnknown, err
+	}
+	nvType := NVType(nvTypeUInt32)
+
+	numElements, err := r.readInt32()
+	if err != nil {
+		return TypeUnknown, err
+	}
+	if numElements < 0 || numElements > 65535 { // 64K entries are enough
+		return TypeUnknown, ErrInvalidData
+	}
+	r.numElements = int(numElements)
+
+	r.dataPos = r.pos
+	r.dataLen = nextNVPairPos - r.pos
+	if r.dataLen < 0 {
+		return TypeUnknown, ErrInvalidData
+	}
+	r.currentToken = nvType
+	r.value = nil
+
+	switch nvType {
+	case TypeNvlist:
+		// The pairs of the embedded nvlist follow its header, Next continues with them.
+		if err := r.checkValue(nvType); err != nil {
+			return TypeUnknown, err
+		}
+		if err := r.pushFrame(false); err != nil {
+			return TypeUnknown, err
+		}
+		r.pos += xdrNvlistHeaderSize
+	case TypeNvlistArray:
+		if err := r.checkValue(nvType); err != nil {
+			return TypeUnknown, err
+		}
+		if r.numElements > 0 {
+			if err := r.pushFrame(true); err != nil {
+				return TypeUnknown, err
+			}
+			r.pos += xdrNvlistHeaderSize
+		}
+	default:
+		var n int
+		r.value, n, err = decodeXDRValue(nvType, r.numElements, r.Data[r.dataPos:nextNVPairPos])
+		if err != nil {
+			return TypeUnknown, err
+		}
+		if err := r.checkValue(nvType); err != nil {
+			return TypeUnknown, err
+		}
+		if r.Validate && n != r.dataLen {
+			return TypeUnknown, fmt.Errorf("%w: value size does not match pair size", ErrInvalidData)
+		}
+		r.pos = nextNVPairPos
+	}
+
+	return nvType, nil
+}
+
+// xdrDecoder reads XDR primitives from a buffer.
+type xdrDecoder struct {
+	b   []byte
+	pos int
+}
+
+func (d *xdrDecoder) uint32() (uint32, error) {
+	if d.pos+4 > len(d.b) {
+		return 0, ErrInvalidData
+	}
+	v := binary.BigEndian.Uint32(d.b[d.pos:])
+	d.pos += 4
+	return v, nil
+}
+
+func (d *xdrDecoder) uint64() (uint64, error) {
+	if d.pos+8 > len(d.b) {
+		return 0, ErrInvalidData
+	}
+	v := binary.BigEndian.Uint64(d.b[d.pos:])
+	d.pos += 8
+	return v, nil
+}
+
+// opaque reads n bytes followed by padding to the next multiple of four.
+func (d *xdrDecoder) opaque(n int) ([]byte, error) {
+	if n < 0 || d.pos+align4(n) > len(d.b) {
+		return nil, ErrInvalidData
+	}
+	v := d.b[d.pos : d.pos+n]
+	d.pos += align4(n)
+	return v, nil
+}
+
+func (d *xdrDecoder) string() ([]byte, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > len(d.b) {
+		return nil, ErrInvalidData
+	}
+	return d.opaque(int(n))
+}
+
+// arrayCount reads the element count XDR prepends to variable-length arrays and checks that it matches
+// the count stored in the pair.
+func (d *xdrDecoder) arrayCount(numElements int) error {
+	n, err := d.uint32()
+	if err != nil {
+		return err
+	}
+	if int(n) != numElements {
+		return ErrInvalidData
+	}
+	return nil
+}
+
+// decodeXDRValue converts an XDR encoded value into the layout the value would have in native encoding and
+// host byte order, so that all accessors work the same for both encodings. XDR widens all integers smaller
+// than 32 bit to 32 bit. It also returns the number of bytes the value took up.
+func decodeXDRValue(t NVType, numElements int, b []byte) ([]byte, int, error) {
+	d := xdrDecoder{b: b}
+	v, err := d.value(t, numElements)
+	return v, d.pos, err
+}
+
+func (d *xdrDecoder) value(t NVType, numElements int) ([]byte, error) {
+	switch t {
+	case TypeBoolean:
+		return nil, nil
+	case TypeByte, TypeInt8, TypeUint8:
+		v, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		return []byte{byte(v)}, nil
+	case TypeInt16, TypeUint16:
+		v, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		return binary.NativeEndian.AppendUint16(alignedBuffer(2)[:0], uint16(v)), nil
+	case TypeInt32, TypeUint32, TypeBooleanValue:
+		v, err := d.uint32()
+		if err != nil {
+			return nil, err
+		}
+		return binary.NativeEndian.AppendUint32(alignedBuffer(4)[:0], v), nil
+	case TypeInt64, TypeUint64, TypeHrtime, TypeDouble:
+		v, err := d.uint64()
+		if err != nil {
+			return nil, err
+		}
+		return binary.NativeEndian.AppendUint64(alignedBuffer(8)[:0], v), nil
+	case TypeString:
+		s, err := d.string()
+		if err != nil {
+			return nil, err
+		}
+		return append(append(alignedBuffer(len(s) + 1)[:0], s...), 0x00), nil
+	case TypeByteArray:
+		return d.opaque(numElements)
+	case TypeInt8Array, TypeUint8Array:
+		if err := d.arrayCount(numElements); err != nil {
+			return nil, err
+		}
+		out := alignedBuffer(numElements)
+		for i := range numElements {
+			v, err := d.uint32()
+			if err != nil {
+				return nil, err
+			}
+			out[i] = byte(v)
+		}
+		return out, nil
+	case TypeInt16Array, TypeUint16Array:
+		if err := d.arrayCount(numElements); err != nil {
+			return nil, err
+		}
+		out := alignedBuffer(2 * numElements)
+		for i := range numElements {
+			v, err := d.uint32()
+			if err != nil {
+				return nil, err
+			}
+			binary.NativeEndian.PutUint16(out[2*i:], uint16(v))
+		}
+		return out, nil
+	case TypeInt32Array, TypeUint32Array, TypeBooleanArray:
+		if err := d.arrayCount(numElements); err != nil {
+			return nil, err
+		}
+		out := alignedBuffer(4 * numElements)
+		for i := range numElements {
+			v, err := d.uint32()
+			if err != nil {
+				return nil, err
+			}
+			binary.NativeEndian.PutUint32(out[4*i:], v)
+		}
+		return out, nil
+	case TypeInt64Array, TypeUint64Array:
+		if err := d.arrayCount(numElements); err != nil {
+			return nil, err
+		}
+		out := alignedBuffer(8 * numElements)
+		for i := range numElements {
+			v, err := d.uint64()
+			if err != nil {
+				return nil, err
+			}
+			binary.NativeEndian.PutUint64(out[8*i:], v)
+		}
+		return out, nil
+	case TypeStringArray:
+		// Unlike the other arrays, string arrays are not prefixed by their length.
+		var out []byte
+		for range numElements {
+			s, err := d.string()
+			if err != nil {
+				return nil, err
+			}
+			out = append(append(out, s...), 0x00)
+		}
+		if out == nil {
+			out = []byte{}
+		}
+		return out, nil
+	default:
+		return nil, ErrInvalidData
+	}
+}
+
+// elementSize returns the size of a single element of the (array) type t if its values are integers that
+// need to be byte swapped, or 0 otherwise.
+func elementSize(t NVType) int {
+	switch t {
+	case TypeInt16, TypeUint16, TypeInt16Array, TypeUint16Array:
+		return 2
+	case TypeInt32, TypeUint32, TypeBooleanValue, TypeInt32Array, TypeUint32Array, TypeBooleanArray:
+		return 4
+	case TypeInt64, TypeUint64, TypeHrtime, TypeDouble, TypeInt64Array, TypeUint64Array:
+		return 8
+	default:
+		return 0
+	}
+}
+
+// swapValue converts a value in native encoding from the foreign byte order into the host byte order.
+func swapValue(t NVType, b []byte) []byte {
+	size := elementSize(t)
+	if size == 0 {
+		return b
+	}
+	out := alignedBuffer(len(b) - len(b)%size)
+	for i := 0; i+size <= len(b); i += size {
+		for j := range size {
+			out[i+j] = b[i+size-1-j]
+		}
+	}
+	return out
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/nvlist"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cachedPool is a pool entry of the zpool.cache file, which ZFS uses to import pools on boot.
+type cachedPool struct {
+	name     string
+	guid     uint64
+	txg      uint64
+	hostname string
+}
+
+func (p *cachedPool) parseConfig(r *nvlist.NVListReader) error {
+	for {
+		token, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		switch r.Name() {
+		case "pool_guid":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for pool_guid")
+			}
+			p.guid = r.UInt64()
+		case "txg":
+			if token != nvlist.TypeUint64 {
+				return fmt.Errorf("invalid type for txg")
+			}
+			p.txg = r.UInt64()
+		case "hostname":
+			if token != nvlist.TypeString {
+				return fmt.Errorf("invalid type for hostname")
+			}
+			s, err := r.String()
+			if err != nil {
+				return err
+			}
+			p.hostname = strings.Clone(s)
+		default:
+			if err := r.SkipValue(token); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func parseZpoolCache(data []byte) ([]cachedPool, error) {
+	var pools []cachedPool
+
+	r := nvlist.NVListReader{Data: data, Validate: true}
+	for {
+		token, err := r.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if token != nvlist.TypeNvlist {
+			return nil, fmt.Errorf("invalid pool config for %q", r.Name())
+		}
+
+		pool := cachedPool{name: strings.Clone(r.Name())}
+		if err := pool.parseConfig(&r); err != nil {
+			return nil, fmt.Errorf("error parsing cached config of pool %q: %w", pool.name, err)
+		}
+		pools = append(pools, pool)
+	}
+
+	return pools, nil
+}
+
+// zpoolCacheCollector exports the pools of the zpool cache file and whether they are currently imported.
+type zpoolCacheCollector struct {
+	// path is the zpool cache file. If it is empty, no cache file is read.
+	path string
+
+	poolCachedNotImported *prometheus.Desc
+	poolCacheTxg          *prometheus.Desc
+	poolCacheInfo         *prometheus.Desc
+}
+
+func newZpoolCacheCollector(config collectorConfig) SystemCollector {
+	return &zpoolCacheCollector{path: config.zpoolCachePath}
+}
+
+func (c *zpoolCacheCollector) Describe(ch *chan<- *prometheus.Desc) {
+	describe(ch, &c.poolCachedNotImported, prometheus.NewDesc("zfs_pool_cached_not_imported", "Whether a pool in the zpool cache file is not imported", []string{"pool", "guid"}, nil))
+	describe(ch, &c.poolCacheTxg, prometheus.NewDesc("zfs_pool_cache_txg", "Last txg of the pool written to the zpool cache file", []string{"pool", "guid"}, nil))
+	describe(ch, &c.poolCacheInfo, prometheus.NewDesc("zfs_pool_cache_info", "Hostname of the pool entry in the zpool cache file", []string{"pool", "guid", "hostname"}, nil))
+}
+
+// Update exports the pools of the cache file. A pool is considered imported if a pool with the same GUID is
+// imported, independent of its name.
+func (c *zpoolCacheCollector) Update(ch *chan<- prometheus.Metric, imported []ioctl.PoolConfig) error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		// Pools imported with cachefile=none or without any pool there is no cache file.
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("error reading zpool cache %q: %w", c.path, err)
+	}
+
+	pools, err := parseZpoolCache(data)
+	if err != nil {
+		return fmt.Errorf("error parsing zpool cache %q: %w", c.path, err)
+	}
+
+	importedGUIDs := make(map[uint64]bool)
+	for _, config := range imported {
+		importedGUIDs[config.GUID] = true
+	}
+	for _, pool := range pools {
+		guid := strconv.FormatUint(pool.guid, 10)
+
+		notImported := 1.0
+		if importedGUIDs[pool.guid] {
+			notImported = 0.0
+		}
+		if err := export(ch, c.poolCachedNotImported, prometheus.GaugeValue, notImported, []string{pool.name, guid}); err != nil {
+			return err
+		}
+		if err := export(ch, c.poolCacheTxg, prometheus.GaugeValue, float64(pool.txg), []string{pool.name, guid}); err != nil {
+			return err
+		}
+		if err := export(ch, c.poolCacheInfo, prometheus.GaugeValue, 1, []string{pool.name, guid, pool.hostname}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/ioctl"
+	"github.com/ReneHollander/prometheus-zfs-exporter/zfs/nvlist"
+)
+
+func TestParseZpoolCache(t *testing.T) {
+	data, err := os.ReadFile("zfs/nvlist/testdata/zpool.cache.xdr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pools, err := parseZpoolCache(data)
+	if err != nil {
+		t.Fatalf("parseZpoolCache() failed: %v", err)
+	}
+
+	want := cachedPool{name: "tank", guid: 0x8d2b0e4c6a1f3e57, txg: 1234567, hostname: "fileserver"}
+	if len(pools) != 1 || pools[0] != want {
+		t.Errorf("got pools %+v, want [%+v]", pools, want)
+	}
+}
+
+// writeZpoolCache writes a zpool cache file holding the pool tank of newFakeZFS and the pool old, which is
+// not imported, and returns its path.
+func writeZpoolCache(t *testing.T) string {
+	cache := nvlist.NVListWriter{}
+	for name, guid := range map[string]uint64{"tank": 1234, "old": 5678} {
+		cache.BeginNvlist(name)
+		cache.AddUInt64("pool_guid", guid)
+		cache.AddUInt64("txg", 42)
+		cache.AddString("hostname", "fileserver")
+		cache.BeginNvlist("vdev_tree")
+		cache.AddString("type", "root")
+		endNvlist(t, &cache)
+		endNvlist(t, &cache)
+	}
+	endNvlist(t, &cache)
+	path := filepath.Join(t.TempDir(), "zpool.cache")
+	if err := os.WriteFile(path, cache.Data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestZpoolCache(t *testing.T) {
+	got := gather(t, &zfsCollector{zfs: &ioctl.Client{Handle: newFakeZFS(t)}, kstatPath: t.TempDir(), zpoolCachePath: writeZpoolCache(t)})
+	for _, want := range []string{
+		`zfs_pool_cached_not_imported{guid="1234",pool="tank"} 0`,
+		`zfs_pool_cached_not_imported{guid="5678",pool="old"} 1`,
+		`zfs_pool_cache_txg{guid="5678",pool="old"} 42`,
+		`zfs_pool_cache_info{guid="5678",hostname="fileserver",pool="old"} 1`,
+	} {
+		if !strings.Contains(got, want+"\n") {
+			t.Errorf("%s missing in:\n%s", want, got)
+		}
+	}
+}